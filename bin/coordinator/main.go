@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"gitlab.com/justnurik/distbuild/pkg/dist"
+	"gitlab.com/justnurik/distbuild/pkg/filecache"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+type flags struct {
+	port      int
+	workDir   string
+	logFile   string
+	fileCache string
+	logLevel  string
+
+	workerTimeout time.Duration
+	sourceFileTTL time.Duration
+	fileCacheSize int64
+	buildTimeout  time.Duration
+}
+
+func main() {
+	var f flags
+
+	cmd := &cobra.Command{
+		Use:          "coordinator",
+		Short:        "Coordinator of the distributed build system",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(f)
+		},
+	}
+
+	cmd.Flags().IntVar(&f.port, "port", 8080, "port for the HTTP server")
+	cmd.Flags().StringVar(&f.workDir, "work-dir", ".", "base directory for coordinator data")
+	cmd.Flags().StringVar(&f.logFile, "log-file", "logs", "path to the log file")
+	cmd.Flags().StringVar(&f.fileCache, "file-cache", "filecache", "file cache directory")
+	cmd.Flags().StringVar(&f.logLevel, "log-level", "error", "log level (debug/info/warn/error)")
+	cmd.Flags().DurationVar(&f.workerTimeout, "worker-timeout", dist.DefaultConfig().Scheduler.WorkerTimeout,
+		"silence after which a worker is considered dead and its jobs are rescheduled")
+	cmd.Flags().Int64Var(&f.fileCacheSize, "file-cache-size", 0,
+		"file cache budget in bytes; least recently used files are evicted (0 means unlimited)")
+	cmd.Flags().DurationVar(&f.sourceFileTTL, "source-file-ttl", dist.DefaultConfig().SourceFileTTL,
+		"how long source files not used by any build are kept in the file cache (0 disables removal)")
+	cmd.Flags().DurationVar(&f.buildTimeout, "build-timeout", 0,
+		"deadline for builds that do not set their own timeout (0 means no deadline)")
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func run(f flags) error {
+	logger, err := newLogger(resolve(f.workDir, f.logFile), f.logLevel)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = logger.Sync() }()
+
+	fileCache, err := filecache.NewWithConfig(resolve(f.workDir, f.fileCache), filecache.Config{MaxSize: f.fileCacheSize})
+	if err != nil {
+		logger.Error("failed to create file cache", zap.Error(err))
+		return fmt.Errorf("failed to create file cache: %w", err)
+	}
+
+	config := dist.DefaultConfig()
+	config.Scheduler.WorkerTimeout = f.workerTimeout
+	config.SourceFileTTL = f.sourceFileTTL
+	config.BuildTimeout = f.buildTimeout
+
+	coordinator := dist.NewCoordinatorWithConfig(logger, fileCache, config)
+	defer coordinator.Stop()
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", f.port),
+		Handler: coordinator,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("coordinator started", zap.String("addr", server.Addr))
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		logger.Error("http server stopped", zap.Error(err))
+		return fmt.Errorf("http server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("graceful shutdown failed", zap.Error(err))
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func resolve(workDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(workDir, path)
+}
+
+func newLogger(logFile, level string) (*zap.Logger, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logFile), 0777); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	cfg.OutputPaths = []string{logFile}
+	cfg.ErrorOutputPaths = []string{logFile, "stderr"}
+
+	return cfg.Build()
+}
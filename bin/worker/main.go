@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"gitlab.com/justnurik/distbuild/pkg/api"
+	"gitlab.com/justnurik/distbuild/pkg/artifact"
+	"gitlab.com/justnurik/distbuild/pkg/filecache"
+	"gitlab.com/justnurik/distbuild/pkg/worker"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+type flags struct {
+	id                string
+	host              string
+	port              int
+	root              string
+	logFile           string
+	metricsFile       string
+	fileCache         string
+	artifacts         string
+	coordinator       string
+	slots             int
+	fileCacheSize     int64
+	artifactsSize     int64
+	inventoryInterval time.Duration
+	maxArtifactBytes  int64
+	maxArtifactFiles  int
+	keepFailedJobDirs bool
+	sandbox           bool
+	sandboxPaths      []string
+	logLevel          string
+}
+
+func main() {
+	worker.RunHelperIfRequested()
+
+	var f flags
+
+	cmd := &cobra.Command{
+		Use:          "worker",
+		Short:        "Worker of the distributed build system",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(f)
+		},
+	}
+
+	cmd.Flags().StringVar(&f.id, "id", "0", "unique worker identifier")
+	cmd.Flags().StringVar(&f.host, "host", "localhost", "host name under which the coordinator and other workers reach this worker")
+	cmd.Flags().IntVar(&f.port, "port", 8080, "port for the HTTP server")
+	cmd.Flags().StringVar(&f.root, "root", ".", "root working directory")
+	cmd.Flags().StringVar(&f.logFile, "log-file", "logs", "path to the log file")
+	cmd.Flags().StringVar(&f.metricsFile, "metrics-file", "",
+		"file to which the number of running jobs is periodically drawn (empty disables it)")
+	cmd.Flags().StringVar(&f.fileCache, "file-cache", "filecache", "file cache directory")
+	cmd.Flags().StringVar(&f.artifacts, "artifacts", "artifacts", "build artifacts directory")
+	cmd.Flags().StringVar(&f.coordinator, "coordinator", "", "coordinator URL")
+	cmd.Flags().StringVar(&f.logLevel, "log-level", "error", "log level (debug/info/warn/error)")
+	cmd.Flags().Int64Var(&f.fileCacheSize, "file-cache-size", 0,
+		"file cache budget in bytes; least recently used files are evicted (0 means unlimited)")
+	cmd.Flags().Int64Var(&f.artifactsSize, "artifacts-size", 0,
+		"artifact cache budget in bytes; least recently used artifacts are evicted (0 means unlimited)")
+	cmd.Flags().Int64Var(&f.maxArtifactBytes, "max-artifact-bytes", 0,
+		"maximum total size of files in an artifact downloaded from another worker (0 means unlimited)")
+	cmd.Flags().IntVar(&f.maxArtifactFiles, "max-artifact-entries", 0,
+		"maximum number of entries in an artifact downloaded from another worker (0 means unlimited)")
+	cmd.Flags().IntVar(&f.slots, "slots", 0, "number of jobs run concurrently (0 means the number of CPUs)")
+	cmd.Flags().DurationVar(&f.inventoryInterval, "inventory-interval", worker.DefaultConfig().InventoryInterval,
+		"how often the artifact cache is reconciled with the coordinator")
+	cmd.Flags().BoolVar(&f.keepFailedJobDirs, "keep-failed-job-dirs", false,
+		"keep directories of failed jobs under <root>/jobs for debugging")
+	cmd.Flags().BoolVar(&f.sandbox, "sandbox", false,
+		"run exec commands in Linux namespaces with access only to the job's files")
+	cmd.Flags().StringSliceVar(&f.sandboxPaths, "sandbox-ro-path", worker.DefaultConfig().SandboxReadOnlyPaths,
+		"host paths visible read-only inside the sandbox (toolchains)")
+
+	_ = cmd.MarkFlagRequired("coordinator")
+
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func run(f flags) error {
+	logger, err := newLogger(resolve(f.root, f.logFile), f.logLevel)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = logger.Sync() }()
+
+	fileCache, err := filecache.NewWithConfig(resolve(f.root, f.fileCache), filecache.Config{MaxSize: f.fileCacheSize})
+	if err != nil {
+		logger.Error("failed to create file cache", zap.Error(err))
+		return fmt.Errorf("failed to create file cache: %w", err)
+	}
+
+	artifacts, err := artifact.NewCacheWithConfig(resolve(f.root, f.artifacts), artifact.Config{MaxSize: f.artifactsSize})
+	if err != nil {
+		logger.Error("failed to create artifact cache", zap.Error(err))
+		return fmt.Errorf("failed to create artifact cache: %w", err)
+	}
+
+	prefix := "/worker/" + f.id
+	workerID := api.WorkerID(fmt.Sprintf("http://%s:%d%s", f.host, f.port, prefix))
+
+	config := worker.DefaultConfig()
+	config.RootDir = f.root
+	config.Slots = f.slots
+	config.InventoryInterval = f.inventoryInterval
+	config.MaxArtifactBytes = f.maxArtifactBytes
+	config.MaxArtifactEntries = f.maxArtifactFiles
+	config.KeepFailedJobDirs = f.keepFailedJobDirs
+	if f.metricsFile != "" {
+		config.MetricsFile = resolve(f.root, f.metricsFile)
+	}
+	config.Sandbox = f.sandbox
+	config.SandboxReadOnlyPaths = f.sandboxPaths
+
+	w := worker.NewWithConfig(workerID, f.coordinator, logger, fileCache, artifacts, config)
+
+	router := http.NewServeMux()
+	router.Handle(prefix+"/", http.StripPrefix(prefix, w))
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", f.port),
+		Handler: router,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("worker started",
+			zap.String("addr", server.Addr),
+			zap.String("worker_id", workerID.String()))
+		serveErr <- server.ListenAndServe()
+	}()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- w.Run(ctx)
+	}()
+
+	var result error
+
+	select {
+	case err := <-serveErr:
+		logger.Error("http server stopped", zap.Error(err))
+		stop()
+		<-runErr
+		return fmt.Errorf("http server stopped: %w", err)
+
+	case err := <-runErr:
+		if !errors.Is(err, context.Canceled) {
+			logger.Error("worker stopped", zap.Error(err))
+			result = fmt.Errorf("worker stopped: %w", err)
+		}
+	}
+
+	logger.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("graceful shutdown failed", zap.Error(err))
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return result
+}
+
+func resolve(root, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+func newLogger(logFile, level string) (*zap.Logger, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logFile), 0777); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	cfg.OutputPaths = []string{logFile}
+	cfg.ErrorOutputPaths = []string{logFile, "stderr"}
+
+	return cfg.Build()
+}
@@ -0,0 +1,57 @@
+package disttest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// wideDeepGraph строит width независимых цепочек глубины depth и джоб, который зависит от концов всех цепочек.
+func wideDeepGraph(width, depth int) build.Graph {
+	var graph build.Graph
+	var tails []build.ID
+
+	for range width {
+		var prev []build.ID
+
+		for range depth {
+			job := build.Job{
+				ID:   build.NewID(),
+				Name: "sleep",
+				Cmds: []build.Cmd{
+					{Exec: []string{"sleep", "0.1"}},
+				},
+				Deps: prev,
+			}
+
+			graph.Jobs = append(graph.Jobs, job)
+			prev = []build.ID{job.ID}
+		}
+
+		tails = append(tails, prev...)
+	}
+
+	graph.Jobs = append(graph.Jobs, build.Job{
+		ID:   build.NewID(),
+		Name: "join",
+		Cmds: []build.Cmd{
+			{Exec: []string{"echo", "OK"}},
+		},
+		Deps: tails,
+	})
+
+	return graph
+}
+
+func BenchmarkWideDeepGraph(b *testing.B) {
+	env := newEnv(b, threeWorkerConfig)
+
+	b.ResetTimer()
+	for range b.N {
+		recorder := NewRecorder()
+		require.NoError(b, env.Client.Build(env.Ctx, wideDeepGraph(3, 3), recorder))
+		require.Len(b, recorder.Jobs, 3*3+1)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -36,8 +37,28 @@ type env struct {
 	Client      *client.Client
 	Coordinator *dist.Coordinator
 	Workers     []*worker.Worker
+	WorkerIDs   []api.WorkerID
 	WorkerCache []*artifact.Cache
 
+	CoordinatorEndpoint string
+	CoordinatorCache    *filecache.Cache
+
+	coordinatorConfig dist.Config
+	coordinators      []*dist.Coordinator
+
+	// coordinator - координатор, который сейчас обслуживает запросы, см. RestartCoordinator.
+	coordinator atomic.Pointer[coordinatorInstance]
+
+	// CoordinatorDown имитирует недоступность координатора: пока флаг выставлен,
+	// новые запросы к координатору получают 503.
+	CoordinatorDown atomic.Bool
+
+	// workers - воркеры, которые сейчас обслуживают запросы, см. RestartWorker.
+	workers       []atomic.Pointer[worker.Worker]
+	workerConfigs []worker.Config
+	stopWorkers   []context.CancelFunc
+	workerDone    []chan struct{}
+
 	HTTP *http.Server
 }
 
@@ -45,13 +66,31 @@ const (
 	logToStderr = true
 )
 
+// coordinatorInstance - запущенный координатор. Отмена ctx обрывает все его запросы.
+type coordinatorInstance struct {
+	*dist.Coordinator
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
 type Config struct {
 	WorkerCount int
+
+	// WorkerTimeout переопределяет таймаут, после которого координатор считает воркер мёртвым.
+	WorkerTimeout time.Duration
+
+	// Sandbox включает исполнение команд воркеров в песочнице.
+	Sandbox bool
+
+	// WorkerSlots переопределяет число слотов каждого воркера.
+	WorkerSlots int
 }
 
-func newEnv(t *testing.T, config *Config) (e *env) {
+func newEnv(t testing.TB, config *Config) (e *env) {
+	ignoreCurrent := goleak.IgnoreCurrent()
 	t.Cleanup(func() {
-		goleak.VerifyNone(t)
+		goleak.VerifyNone(t, ignoreCurrent)
 	})
 
 	cwd, err := os.Getwd()
@@ -117,42 +156,60 @@ func newEnv(t *testing.T, config *Config) (e *env) {
 	coordinatorCache, err := filecache.New(filepath.Join(env.RootDir, "coordinator", "filecache"))
 	require.NoError(t, err)
 
-	env.Coordinator = dist.NewCoordinator(
-		env.Logger.Named("coordinator"),
-		coordinatorCache,
-	)
-	t.Cleanup(env.Coordinator.Stop)
+	coordinatorConfig := dist.DefaultConfig()
+	if config.WorkerTimeout != 0 {
+		coordinatorConfig.Scheduler.WorkerTimeout = config.WorkerTimeout
+	}
+
+	env.CoordinatorEndpoint = coordinatorEndpoint
+	env.CoordinatorCache = coordinatorCache
+	env.coordinatorConfig = coordinatorConfig
+	env.startCoordinator()
+	t.Cleanup(func() {
+		for _, c := range env.coordinators {
+			c.Stop()
+		}
+	})
 
 	router := http.NewServeMux()
-	router.Handle("/coordinator/", http.StripPrefix("/coordinator", env.Coordinator))
+	router.Handle("/coordinator/", http.StripPrefix("/coordinator", http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if env.CoordinatorDown.Load() {
+				http.Error(w, "coordinator is down", http.StatusServiceUnavailable)
+				return
+			}
 
-	for i := 0; i < config.WorkerCount; i++ {
-		workerName := fmt.Sprintf("worker%d", i)
-		workerDir := filepath.Join(env.RootDir, workerName)
+			c := env.coordinator.Load()
 
-		var fileCache *filecache.Cache
-		fileCache, err = filecache.New(filepath.Join(workerDir, "filecache"))
-		require.NoError(t, err)
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			defer context.AfterFunc(c.ctx, cancel)()
 
-		var artifacts *artifact.Cache
-		artifacts, err = artifact.NewCache(filepath.Join(workerDir, "artifacts"))
-		require.NoError(t, err)
+			c.ServeHTTP(w, r.WithContext(ctx))
+		})))
 
+	env.workers = make([]atomic.Pointer[worker.Worker], config.WorkerCount)
+	for i := 0; i < config.WorkerCount; i++ {
 		workerPrefix := fmt.Sprintf("/worker/%d", i)
-		workerID := api.WorkerID("http://" + addr + workerPrefix)
 
-		w := worker.New(
-			workerID,
-			coordinatorEndpoint,
-			env.Logger.Named(workerName),
-			fileCache,
-			artifacts,
-		)
+		workerConfig := worker.DefaultConfig()
+		workerConfig.RootDir = filepath.Join(env.RootDir, fmt.Sprintf("worker%d", i))
+		workerConfig.Sandbox = config.Sandbox
+		workerConfig.Slots = config.WorkerSlots
 
-		env.Workers = append(env.Workers, w)
-		env.WorkerCache = append(env.WorkerCache, artifacts)
+		env.WorkerIDs = append(env.WorkerIDs, api.WorkerID("http://"+addr+workerPrefix))
+		env.workerConfigs = append(env.workerConfigs, workerConfig)
+		env.Workers = append(env.Workers, nil)
+		env.WorkerCache = append(env.WorkerCache, nil)
+		env.stopWorkers = append(env.stopWorkers, nil)
+		env.workerDone = append(env.workerDone, nil)
 
-		router.Handle(workerPrefix+"/", http.StripPrefix(workerPrefix, w))
+		require.NoError(t, env.newWorker(i))
+
+		router.Handle(workerPrefix+"/", http.StripPrefix(workerPrefix, http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				env.workers[i].Load().ServeHTTP(w, r)
+			})))
 	}
 
 	env.HTTP = &http.Server{
@@ -175,15 +232,8 @@ func newEnv(t *testing.T, config *Config) (e *env) {
 		_ = env.HTTP.Shutdown(context.Background())
 	})
 
-	for _, w := range env.Workers {
-		go func(w *worker.Worker) {
-			err := w.Run(env.Ctx)
-			if errors.Is(err, context.Canceled) {
-				return
-			}
-
-			env.Logger.Fatal("worker stopped", zap.Error(err))
-		}(w)
+	for i := range env.Workers {
+		env.runWorker(i)
 	}
 
 	go func() {
@@ -198,6 +248,90 @@ func newEnv(t *testing.T, config *Config) (e *env) {
 	return env
 }
 
+func (e *env) startCoordinator() {
+	ctx, cancel := context.WithCancel(e.Ctx)
+
+	e.Coordinator = dist.NewCoordinatorWithConfig(
+		e.Logger.Named("coordinator"),
+		e.CoordinatorCache,
+		e.coordinatorConfig,
+	)
+	e.coordinators = append(e.coordinators, e.Coordinator)
+	e.coordinator.Store(&coordinatorInstance{Coordinator: e.Coordinator, ctx: ctx, cancel: cancel})
+}
+
+// RestartCoordinator заменяет координатор новым с тем же кешем файлов, имитируя перезапуск:
+// запросы к старому координатору обрываются, а новый ничего не знает о воркерах.
+func (e *env) RestartCoordinator() {
+	old := e.coordinator.Load()
+	e.startCoordinator()
+	old.cancel()
+}
+
+// newWorker создаёт i-го воркера поверх его директорий.
+func (e *env) newWorker(i int) error {
+	config := e.workerConfigs[i]
+
+	fileCache, err := filecache.New(filepath.Join(config.RootDir, "filecache"))
+	if err != nil {
+		return err
+	}
+
+	artifacts, err := artifact.NewCache(filepath.Join(config.RootDir, "artifacts"))
+	if err != nil {
+		return err
+	}
+
+	w := worker.NewWithConfig(
+		e.WorkerIDs[i],
+		e.CoordinatorEndpoint,
+		e.Logger.Named(fmt.Sprintf("worker%d", i)),
+		fileCache,
+		artifacts,
+		config,
+	)
+
+	e.Workers[i] = w
+	e.WorkerCache[i] = artifacts
+	e.workers[i].Store(w)
+	return nil
+}
+
+// runWorker запускает цикл heartbeat-ов i-го воркера.
+func (e *env) runWorker(i int) {
+	ctx, stop := context.WithCancel(e.Ctx)
+	done := make(chan struct{})
+
+	e.stopWorkers[i] = stop
+	e.workerDone[i] = done
+
+	go func(w *worker.Worker) {
+		defer close(done)
+
+		err := w.Run(ctx)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		e.Logger.Fatal("worker stopped", zap.Error(err))
+	}(e.Workers[i])
+}
+
+// RestartWorker перезапускает i-го воркера: новый воркер работает с теми же кешами на диске,
+// но ничего не помнит в памяти.
+func (e *env) RestartWorker(t testing.TB, i int) {
+	e.StopWorker(i)
+	<-e.workerDone[i]
+
+	require.NoError(t, e.newWorker(i))
+	e.runWorker(i)
+}
+
+// StopWorker останавливает цикл heartbeat-ов i-го воркера, имитируя его падение.
+func (e *env) StopWorker(i int) {
+	e.stopWorkers[i]()
+}
+
 func newWinFileSink(u *url.URL) (zap.Sink, error) {
 	if len(u.Opaque) > 0 {
 		// Remove leading slash left by url.Parse()
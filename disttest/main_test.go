@@ -0,0 +1,15 @@
+package disttest
+
+import (
+	"os"
+	"testing"
+
+	"gitlab.com/justnurik/distbuild/pkg/worker"
+)
+
+func TestMain(m *testing.M) {
+	// Воркеры с песочницей и ограничениями ресурсов перезапускают тестовый бинарь.
+	worker.RunHelperIfRequested()
+
+	os.Exit(m.Run())
+}
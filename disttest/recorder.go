@@ -1,6 +1,7 @@
 package disttest
 
 import (
+	"gitlab.com/justnurik/distbuild/pkg/api"
 	"gitlab.com/justnurik/distbuild/pkg/build"
 )
 
@@ -10,15 +11,22 @@ type JobResult struct {
 
 	Code  *int
 	Error string
+
+	// SkippedBecause задаёт упавшую зависимость, из-за которой джоб не запускался.
+	SkippedBecause *build.ID
 }
 
 type Recorder struct {
 	Jobs map[build.ID]*JobResult
+
+	// Resources хранит ресурсы, потраченные исполнявшимися джобами.
+	Resources map[build.ID]api.ResourceUsage
 }
 
 func NewRecorder() *Recorder {
 	return &Recorder{
-		Jobs: map[build.ID]*JobResult{},
+		Jobs:      map[build.ID]*JobResult{},
+		Resources: map[build.ID]api.ResourceUsage{},
 	}
 }
 
@@ -55,3 +63,14 @@ func (r *Recorder) OnJobFailed(jobID build.ID, code int, error string) error {
 	j.Error = error
 	return nil
 }
+
+func (r *Recorder) OnJobSkipped(jobID build.ID, failedDep build.ID) error {
+	j := r.job(jobID)
+	j.SkippedBecause = &failedDep
+	return nil
+}
+
+func (r *Recorder) OnJobResources(jobID build.ID, usage api.ResourceUsage) error {
+	r.Resources[jobID] = usage
+	return nil
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+package disttest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/justnurik/distbuild/pkg/api"
+	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/client"
+)
+
+func TestResourceLimits(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	graph := build.Graph{
+		Jobs: []build.Job{
+			{
+				ID:   build.ID{'u'},
+				Name: "usage",
+				Cmds: []build.Cmd{
+					{Exec: []string{"bash", "-c", "head -c 1000 /dev/zero > {{.OutputDir}}/out; echo OK"}},
+				},
+				Limits: build.Limits{Output: 4096},
+			},
+			{
+				ID:     build.ID{'o'},
+				Name:   "output",
+				Cmds:   []build.Cmd{{Exec: []string{"head", "-c", "100000", "/dev/zero"}}},
+				Limits: build.Limits{Output: 1000},
+			},
+			{
+				ID:     build.ID{'w'},
+				Name:   "wall_time",
+				Cmds:   []build.Cmd{{Exec: []string{"sleep", "10"}}},
+				Limits: build.Limits{WallTime: 100 * time.Millisecond},
+			},
+			{
+				ID:     build.ID{'c'},
+				Name:   "cpu_time",
+				Cmds:   []build.Cmd{{Exec: []string{"bash", "-c", "while :; do :; done"}}},
+				Limits: build.Limits{CPUTime: time.Second},
+			},
+			{
+				// Каждый потомок по отдельности не выходит за ограничение, а джоб в целом выходит.
+				ID:     build.ID{'f'},
+				Name:   "cpu_time_forks",
+				Cmds:   []build.Cmd{{Exec: []string{"bash", "-c", "for i in 1 2 3 4; do (while :; do :; done) & done; wait"}}},
+				Limits: build.Limits{CPUTime: time.Second},
+			},
+			{
+				ID:     build.ID{'m'},
+				Name:   "memory",
+				Cmds:   []build.Cmd{{Exec: []string{"bash", "-c", "x=$(head -c 300000000 /dev/zero | tr '\\0' a); echo ${#x}"}}},
+				Limits: build.Limits{Memory: 64 << 20},
+			},
+		},
+	}
+
+	recorder := NewRecorder()
+	err := env.Client.BuildWithOptions(env.Ctx, graph, client.BuildOptions{Mode: api.BuildModeKeepGoing}, recorder)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "5 jobs failed")
+
+	require.Equal(t, &JobResult{Stdout: "OK\n", Code: new(int)}, recorder.Jobs[build.ID{'u'}])
+	usage := recorder.Resources[build.ID{'u'}]
+	assert.Equal(t, int64(1003), usage.BytesWritten)
+	assert.Positive(t, usage.MaxRSS)
+
+	assert.Contains(t, recorder.Jobs[build.ID{'o'}].Error, "output limit exceeded")
+	assert.Contains(t, recorder.Jobs[build.ID{'w'}].Error, "wall time limit")
+
+	assert.NotEqual(t, 0, *recorder.Jobs[build.ID{'c'}].Code)
+	assert.Contains(t, recorder.Jobs[build.ID{'c'}].Error, "cpu time limit exceeded")
+	assert.GreaterOrEqual(t, recorder.Resources[build.ID{'c'}].UserTime+recorder.Resources[build.ID{'c'}].SystemTime,
+		500*time.Millisecond)
+
+	assert.Contains(t, recorder.Jobs[build.ID{'f'}].Error, "cpu time limit exceeded")
+	assert.Less(t, recorder.Resources[build.ID{'f'}].UserTime+recorder.Resources[build.ID{'f'}].SystemTime,
+		2*time.Second)
+
+	assert.NotEqual(t, 0, *recorder.Jobs[build.ID{'m'}].Code)
+	assert.Contains(t, recorder.Jobs[build.ID{'m'}].Error, "memory limit exceeded")
+	assert.Less(t, recorder.Resources[build.ID{'m'}].MaxRSS, int64(300<<20))
+}
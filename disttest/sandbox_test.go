@@ -0,0 +1,94 @@
+//go:build linux
+
+package disttest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+var sandboxConfig = &Config{WorkerCount: 1, Sandbox: true}
+
+// requireUserNamespaces пропускает тест, если процессу нельзя создать user namespace
+// (например, выключен kernel.unprivileged_userns_clone или их запрещает seccomp-профиль Docker).
+func requireUserNamespaces(t *testing.T) {
+	t.Helper()
+
+	cmd := exec.Command("true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER |
+			syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWNET |
+			syscall.CLONE_NEWIPC |
+			syscall.CLONE_NEWUTS,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+
+	if err := cmd.Run(); err != nil {
+		t.Skipf("user namespaces are unavailable: %v", err)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	requireUserNamespaces(t)
+
+	env := newEnv(t, sandboxConfig)
+
+	secret := filepath.Join(env.RootDir, "secret.txt")
+	require.NoError(t, os.WriteFile(secret, []byte("secret"), 0644))
+
+	out := fmt.Sprintf("{{index .Deps %q}}/out.txt", build.ID{'a'})
+
+	graph := build.Graph{
+		SourceFiles: map[build.ID]string{{'f'}: "a.txt"},
+		Jobs: []build.Job{
+			{
+				ID:     build.ID{'a'},
+				Name:   "write",
+				Cmds:   []build.Cmd{{Exec: []string{"bash", "-c", "cat {{.SourceDir}}/a.txt > {{.OutputDir}}/out.txt"}}},
+				Inputs: []string{"a.txt"},
+			},
+			{
+				ID:   build.ID{'b'},
+				Name: "read_dep",
+				Cmds: []build.Cmd{{Exec: []string{"cat", out}}},
+				Deps: []build.ID{{'a'}},
+			},
+		},
+	}
+
+	recorder := NewRecorder()
+	require.NoError(t, env.Client.Build(env.Ctx, graph, recorder))
+	assert.Equal(t, "foo", recorder.Jobs[build.ID{'b'}].Stdout)
+
+	undeclared := build.Graph{
+		Jobs: []build.Job{
+			{
+				ID:   build.ID{'c'},
+				Name: "read_undeclared",
+				Cmds: []build.Cmd{{Exec: []string{"cat", secret}}},
+			},
+		},
+	}
+
+	recorder = NewRecorder()
+	require.Error(t, env.Client.Build(env.Ctx, undeclared, recorder))
+
+	result := recorder.Jobs[build.ID{'c'}]
+	require.NotNil(t, result)
+	// cat не нашёл файл; код sandboxExitCode значил бы, что песочница не поднялась.
+	assert.Equal(t, 1, *result.Code)
+	assert.NotContains(t, result.Stdout, "secret")
+}
@@ -1,10 +1,14 @@
 package disttest
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -71,6 +75,76 @@ func TestJobCaching(t *testing.T) {
 	require.Equal(t, []byte("NOTOK\n"), output)
 }
 
+func TestJobCachingAfterCoordinatorRestart(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	tmpFile, err := os.CreateTemp("", "")
+	require.NoError(t, err)
+
+	graph := build.Graph{
+		Jobs: []build.Job{
+			{
+				ID:   build.ID{'a'},
+				Name: "echo",
+				Cmds: []build.Cmd{
+					{CatTemplate: "OK\n", CatOutput: tmpFile.Name()}, // No-hermetic, for testing purposes.
+					{Exec: []string{"echo", "OK"}},
+				},
+			},
+		},
+	}
+
+	require.NoError(t, env.Client.Build(env.Ctx, graph, NewRecorder()))
+	require.NoError(t, os.WriteFile(tmpFile.Name(), []byte("NOTOK\n"), 0666))
+
+	// Новый координатор узнаёт об артефакте из описи кеша воркера, который продолжает работать.
+	env.RestartCoordinator()
+
+	recorder := NewRecorder()
+	require.NoError(t, env.Client.Build(env.Ctx, graph, recorder))
+	assert.Equal(t, &JobResult{Stdout: "OK\n", Code: new(int)}, recorder.Jobs[build.ID{'a'}])
+
+	output, err := io.ReadAll(tmpFile)
+	require.NoError(t, err)
+	require.Equal(t, []byte("NOTOK\n"), output)
+}
+
+func TestJobCachingAfterRestart(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	tmpFile, err := os.CreateTemp("", "")
+	require.NoError(t, err)
+
+	graph := build.Graph{
+		Jobs: []build.Job{
+			{
+				ID:   build.ID{'a'},
+				Name: "echo",
+				Cmds: []build.Cmd{
+					{CatTemplate: "OK\n", CatOutput: tmpFile.Name()}, // No-hermetic, for testing purposes.
+					{Exec: []string{"echo", "OK"}},
+				},
+			},
+		},
+	}
+
+	require.NoError(t, env.Client.Build(env.Ctx, graph, NewRecorder()))
+	require.NoError(t, os.WriteFile(tmpFile.Name(), []byte("NOTOK\n"), 0666))
+
+	// Новый координатор узнаёт об артефакте из описи кеша воркера,
+	// а перезапущенный воркер читает результат джоба с диска.
+	env.RestartWorker(t, 0)
+	env.RestartCoordinator()
+
+	recorder := NewRecorder()
+	require.NoError(t, env.Client.Build(env.Ctx, graph, recorder))
+	assert.Equal(t, &JobResult{Stdout: "OK\n", Code: new(int)}, recorder.Jobs[build.ID{'a'}])
+
+	output, err := io.ReadAll(tmpFile)
+	require.NoError(t, err)
+	require.Equal(t, []byte("NOTOK\n"), output)
+}
+
 var sourceFilesGraph = build.Graph{
 	SourceFiles: map[build.ID]string{
 		{'a'}: "a.txt",
@@ -102,6 +176,105 @@ func TestSourceFiles(t *testing.T) {
 	assert.Equal(t, &JobResult{Stdout: "foo", Stderr: "bar", Code: new(int)}, recorder.Jobs[build.ID{'a'}])
 }
 
+func TestSourceFilesKeptBetweenBuilds(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	for i := 0; i < 2; i++ {
+		recorder := NewRecorder()
+		require.NoError(t, env.Client.Build(env.Ctx, sourceFilesGraph, recorder))
+		assert.Equal(t, &JobResult{Stdout: "foo", Stderr: "bar", Code: new(int)}, recorder.Jobs[build.ID{'a'}])
+	}
+
+	// Файлы остаются в кеше координатора, и следующему билду не нужно заливать их заново.
+	for _, path := range sourceFilesGraph.SourceFiles {
+		fileID, err := build.SourceFileID(filepath.Join("testdata", t.Name()), path)
+		require.NoError(t, err)
+		require.True(t, env.CoordinatorCache.Has(fileID), path)
+	}
+}
+
+func TestJobSourceDirIsolated(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	graph := build.Graph{
+		SourceFiles: map[build.ID]string{{'f'}: "a.txt"},
+		Jobs: []build.Job{
+			{
+				ID:     build.ID{'a'},
+				Name:   "with_input",
+				Cmds:   []build.Cmd{{Exec: []string{"ls", "-A", "{{.SourceDir}}"}}},
+				Inputs: []string{"a.txt"},
+			},
+			{
+				ID:   build.ID{'b'},
+				Name: "without_input",
+				Cmds: []build.Cmd{{Exec: []string{"ls", "-A", "{{.SourceDir}}"}}},
+				Deps: []build.ID{{'a'}},
+			},
+		},
+	}
+
+	recorder := NewRecorder()
+	require.NoError(t, env.Client.Build(env.Ctx, graph, recorder))
+
+	require.Len(t, recorder.Jobs, 2)
+	assert.Equal(t, "a.txt\n", recorder.Jobs[build.ID{'a'}].Stdout)
+	assert.Equal(t, "", recorder.Jobs[build.ID{'b'}].Stdout)
+
+	jobDirs, err := os.ReadDir(filepath.Join(env.RootDir, "worker0", "jobs"))
+	require.NoError(t, err)
+	assert.Empty(t, jobDirs)
+}
+
+func TestJobsCannotCorruptInputs(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	out := fmt.Sprintf("{{index .Deps %q}}/out.txt", build.ID{'o'})
+
+	graph := build.Graph{
+		SourceFiles: map[build.ID]string{{'f'}: "a.txt"},
+		Jobs: []build.Job{
+			{
+				ID:     build.ID{'s'},
+				Name:   "spoil_input",
+				Cmds:   []build.Cmd{{Exec: []string{"bash", "-c", "echo bad > {{.SourceDir}}/a.txt"}}},
+				Inputs: []string{"a.txt"},
+			},
+			{
+				ID:     build.ID{'r'},
+				Name:   "read_input",
+				Cmds:   []build.Cmd{{Exec: []string{"cat", "{{.SourceDir}}/a.txt"}}},
+				Inputs: []string{"a.txt"},
+				Deps:   []build.ID{{'s'}},
+			},
+			{
+				ID:   build.ID{'o'},
+				Name: "write",
+				Cmds: []build.Cmd{{CatTemplate: "OK", CatOutput: "{{.OutputDir}}/out.txt"}},
+			},
+			{
+				ID:   build.ID{'d'},
+				Name: "spoil_dep",
+				Cmds: []build.Cmd{{Exec: []string{"bash", "-c", "echo bad > " + out}}},
+				Deps: []build.ID{{'o'}},
+			},
+			{
+				ID:   build.ID{'c'},
+				Name: "read_dep",
+				Cmds: []build.Cmd{{Exec: []string{"cat", out}}},
+				Deps: []build.ID{{'o'}, {'d'}},
+			},
+		},
+	}
+
+	recorder := NewRecorder()
+	require.NoError(t, env.Client.Build(env.Ctx, graph, recorder))
+
+	require.Len(t, recorder.Jobs, 5)
+	assert.Equal(t, "foo", recorder.Jobs[build.ID{'r'}].Stdout)
+	assert.Equal(t, "OK", recorder.Jobs[build.ID{'c'}].Stdout)
+}
+
 var artifactTransferGraph = build.Graph{
 	Jobs: []build.Job{
 		{
@@ -131,3 +304,80 @@ func TestArtifactTransferBetweenJobs(t *testing.T) {
 	assert.Len(t, recorder.Jobs, 2)
 	assert.Equal(t, &JobResult{Stdout: "OK", Code: new(int)}, recorder.Jobs[build.ID{'b'}])
 }
+
+func TestInvalidGraph(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	graph := build.Graph{
+		Jobs: []build.Job{
+			{ID: build.ID{'a'}, Name: "a", Deps: []build.ID{{'b'}}},
+			{ID: build.ID{'b'}, Name: "b", Deps: []build.ID{{'a'}}},
+		},
+	}
+
+	err := env.Client.Build(env.Ctx, graph, NewRecorder())
+	require.Error(t, err)
+
+	var validationErr *build.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	require.Equal(t, build.ProblemCycle, validationErr.Problems[0].Kind)
+}
+
+func TestCancelBuild(t *testing.T) {
+	// Со свободным слотом воркер ждёт новые джобы, и отмена должна дойти до него без следующего билда.
+	env := newEnv(t, &Config{WorkerCount: 1, WorkerSlots: 2})
+
+	pidFile := filepath.Join(t.TempDir(), "sleep.pid")
+	graph := build.Graph{Jobs: []build.Job{sleepJob('s', pidFile)}}
+
+	ctx, cancel := context.WithCancel(env.Ctx)
+	defer cancel()
+
+	buildErr := make(chan error, 1)
+	go func() {
+		buildErr <- env.Client.Build(ctx, graph, NewRecorder())
+	}()
+
+	pid := waitPID(t, pidFile)
+
+	cancel()
+	require.ErrorIs(t, <-buildErr, context.Canceled)
+
+	require.Eventually(t, func() bool { return !processAlive(pid) }, 2*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return len(workerStates(t, env)[0].Jobs) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestCoordinatorUnavailable(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	graph := build.Graph{
+		Jobs: []build.Job{
+			{
+				ID:   build.ID{'a'},
+				Name: "slow",
+				Cmds: []build.Cmd{{Exec: []string{"bash", "-c", "sleep 0.3; echo OK"}}},
+			},
+		},
+	}
+
+	recorder := NewRecorder()
+	buildErr := make(chan error, 1)
+	go func() {
+		buildErr <- env.Client.Build(env.Ctx, graph, recorder)
+	}()
+
+	require.Eventually(t, func() bool {
+		states := workerStates(t, env)
+		return len(states) == 1 && len(states[0].Jobs) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Джоб завершается, пока координатор недоступен: воркер не падает, а копит результат.
+	env.CoordinatorDown.Store(true)
+	time.Sleep(time.Second)
+	env.CoordinatorDown.Store(false)
+
+	require.NoError(t, <-buildErr)
+	require.Equal(t, &JobResult{Stdout: "OK\n", Code: new(int)}, recorder.Jobs[build.ID{'a'}])
+}
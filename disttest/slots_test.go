@@ -0,0 +1,138 @@
+package disttest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// timingRecorder запоминает, когда клиент узнал о завершении каждого джоба.
+type timingRecorder struct {
+	*Recorder
+
+	mu         sync.Mutex
+	finishedAt map[build.ID]time.Time
+}
+
+func (r *timingRecorder) OnJobFinished(jobID build.ID) error {
+	r.mu.Lock()
+	r.finishedAt[jobID] = time.Now()
+	r.mu.Unlock()
+
+	return r.Recorder.OnJobFinished(jobID)
+}
+
+func TestSlowJobDoesNotBlockOtherSlots(t *testing.T) {
+	env := newEnv(t, &Config{WorkerCount: 1, WorkerSlots: 2})
+
+	quick := func(id, dep byte) build.Job {
+		job := build.Job{
+			ID:   build.ID{id},
+			Name: "quick",
+			Cmds: []build.Cmd{{Exec: []string{"echo", string(id)}}},
+		}
+		if dep != 0 {
+			job.Deps = []build.ID{{dep}}
+		}
+		return job
+	}
+
+	graph := build.Graph{
+		Jobs: []build.Job{
+			{
+				ID:   build.ID{'s'},
+				Name: "slow",
+				Cmds: []build.Cmd{{Exec: []string{"sleep", "1"}}},
+			},
+			quick('a', 0),
+			quick('b', 'a'),
+			quick('c', 'b'),
+		},
+	}
+
+	recorder := &timingRecorder{Recorder: NewRecorder(), finishedAt: map[build.ID]time.Time{}}
+	require.NoError(t, env.Client.Build(env.Ctx, graph, recorder))
+
+	require.Len(t, recorder.finishedAt, 4)
+
+	// Цепочка быстрых джобов проходит через второй слот, пока первый занят медленным джобом.
+	require.True(t, recorder.finishedAt[build.ID{'c'}].Before(recorder.finishedAt[build.ID{'s'}]))
+}
+
+func TestCancelWithFreeSlots(t *testing.T) {
+	// Один слот остаётся свободным, поэтому основной цикл воркера висит в long-poll.
+	env := newEnv(t, &Config{WorkerCount: 1, WorkerSlots: 3})
+
+	cancelledPID := filepath.Join(t.TempDir(), "cancelled.pid")
+	runningPID := filepath.Join(t.TempDir(), "running.pid")
+
+	cancelledCtx, cancel := context.WithCancel(env.Ctx)
+	defer cancel()
+	runningCtx, stop := context.WithCancel(env.Ctx)
+	defer stop()
+
+	cancelledErr := make(chan error, 1)
+	go func() {
+		cancelledErr <- env.Client.Build(cancelledCtx, build.Graph{Jobs: []build.Job{sleepJob('c', cancelledPID)}}, NewRecorder())
+	}()
+	go func() {
+		_ = env.Client.Build(runningCtx, build.Graph{Jobs: []build.Job{sleepJob('r', runningPID)}}, NewRecorder())
+	}()
+
+	cancelled := waitPID(t, cancelledPID)
+	running := waitPID(t, runningPID)
+
+	cancel()
+	require.ErrorIs(t, <-cancelledErr, context.Canceled)
+
+	// Отменённый джоб убит без следующего билда, а соседний продолжает работать.
+	require.Eventually(t, func() bool { return !processAlive(cancelled) }, 2*time.Second, 10*time.Millisecond)
+	require.True(t, processAlive(running))
+}
+
+// sleepJob возвращает долгий джоб, который записывает pid своего процесса в pidFile.
+func sleepJob(id byte, pidFile string) build.Job {
+	return build.Job{
+		ID:   build.ID{id},
+		Name: "sleep",
+		Cmds: []build.Cmd{
+			{Exec: []string{"bash", "-c", "echo $$ > " + pidFile + "; exec sleep 30"}, Environ: os.Environ()},
+		},
+	}
+}
+
+// waitPID ждёт, пока джоб запишет pid в pidFile, и возвращает его.
+func waitPID(t *testing.T, pidFile string) int {
+	t.Helper()
+
+	var pid int
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(pidFile)
+		if err != nil || !strings.HasSuffix(string(data), "\n") {
+			return false
+		}
+
+		pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return pid
+}
+
+// processAlive сообщает, что процесс pid существует.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
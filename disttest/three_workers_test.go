@@ -1,14 +1,22 @@
 package disttest
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"slices"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"gitlab.com/justnurik/distbuild/pkg/api"
 	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/client"
+	"gitlab.com/justnurik/distbuild/pkg/scheduler"
 )
 
 var threeWorkerConfig = &Config{WorkerCount: 3}
@@ -61,3 +69,130 @@ func TestArtifactTransferBetweenWorkers(t *testing.T) {
 	// 	defer unlock()
 	// }
 }
+
+func TestJobReassignedOnWorkerLoss(t *testing.T) {
+	env := newEnv(t, &Config{WorkerCount: 3, WorkerTimeout: time.Second})
+
+	job := build.Job{
+		ID:   build.ID{'a'},
+		Name: "slow",
+		Cmds: []build.Cmd{
+			{Exec: []string{"sleep", "1"}, Environ: os.Environ()},
+			{CatTemplate: "OK", CatOutput: "{{.OutputDir}}/out.txt"},
+			{Exec: []string{"echo", "OK"}},
+		},
+	}
+
+	buildErr := make(chan error, 1)
+	recorder := NewRecorder()
+	go func() {
+		buildErr <- env.Client.Build(env.Ctx, build.Graph{Jobs: []build.Job{job}}, recorder)
+	}()
+
+	var owner api.WorkerID
+	require.Eventually(t, func() bool {
+		for _, state := range workerStates(t, env) {
+			if len(state.Jobs) != 0 {
+				owner = state.ID
+				return true
+			}
+		}
+		return false
+	}, 2*time.Second, 10*time.Millisecond)
+
+	env.StopWorker(slices.Index(env.WorkerIDs, owner))
+
+	require.NoError(t, <-buildErr)
+	require.Equal(t, &JobResult{Stdout: "OK\n", Code: new(int)}, recorder.Jobs[job.ID])
+
+	for _, state := range workerStates(t, env) {
+		require.Equal(t, state.ID != owner, state.Alive, "worker %s", state.ID)
+	}
+}
+
+func workerStates(t *testing.T, env *env) []scheduler.WorkerState {
+	rsp, err := http.Get(env.CoordinatorEndpoint + "/workers")
+	require.NoError(t, err)
+	defer func() { _ = rsp.Body.Close() }()
+
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	var states []scheduler.WorkerState
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&states))
+	return states
+}
+
+// failingGraph возвращает граф, в котором джоб 'a' падает, 'b' и 'd' зависят от него,
+// а 'c' от него не зависит и выполняет cmd.
+func failingGraph(cmd build.Cmd) build.Graph {
+	return build.Graph{
+		Jobs: []build.Job{
+			{
+				ID:   build.ID{'a'},
+				Name: "fail",
+				Cmds: []build.Cmd{{Exec: []string{"false"}, Environ: os.Environ()}},
+			},
+			{
+				ID:   build.ID{'b'},
+				Name: "after fail",
+				Deps: []build.ID{{'a'}},
+				Cmds: []build.Cmd{{Exec: []string{"echo", "b"}}},
+			},
+			{
+				ID:   build.ID{'c'},
+				Name: "independent",
+				Cmds: []build.Cmd{cmd},
+			},
+			{
+				ID:   build.ID{'d'},
+				Name: "transitive",
+				Deps: []build.ID{{'b'}},
+				Cmds: []build.Cmd{{Exec: []string{"echo", "d"}}},
+			},
+		},
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	env := newEnv(t, threeWorkerConfig)
+
+	graph := failingGraph(build.Cmd{Exec: []string{"echo", "OK"}})
+
+	recorder := NewRecorder()
+	err := env.Client.BuildWithOptions(env.Ctx, graph, client.BuildOptions{Mode: api.BuildModeKeepGoing}, recorder)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "1 jobs failed")
+
+	require.Len(t, recorder.Jobs, 4)
+	require.Equal(t, 1, *recorder.Jobs[build.ID{'a'}].Code)
+	require.Equal(t, &JobResult{Stdout: "OK\n", Code: new(int)}, recorder.Jobs[build.ID{'c'}])
+	require.Equal(t, &JobResult{SkippedBecause: &build.ID{'a'}}, recorder.Jobs[build.ID{'b'}])
+	require.Equal(t, &JobResult{SkippedBecause: &build.ID{'a'}}, recorder.Jobs[build.ID{'d'}])
+}
+
+func TestFailFast(t *testing.T) {
+	env := newEnv(t, threeWorkerConfig)
+
+	graph := failingGraph(build.Cmd{Exec: []string{"sleep", "5"}, Environ: os.Environ()})
+
+	start := time.Now()
+
+	recorder := NewRecorder()
+	err := env.Client.Build(env.Ctx, graph, recorder)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed")
+	require.Less(t, time.Since(start), 3*time.Second)
+
+	require.Len(t, recorder.Jobs, 1)
+	require.Equal(t, 1, *recorder.Jobs[build.ID{'a'}].Code)
+
+	// Отменённый sleep больше не числится ни за одним воркером.
+	require.Eventually(t, func() bool {
+		for _, state := range workerStates(t, env) {
+			if len(state.Jobs) != 0 {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,57 @@
+package disttest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/client"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	graph := build.Graph{
+		Jobs: []build.Job{
+			{
+				ID:   build.ID{'a'},
+				Name: "hang",
+				Cmds: []build.Cmd{
+					// Фоновый sleep держит stdout: без убийства группы процессов джоб ждал бы его.
+					{Exec: []string{"bash", "-c", "sleep 30 & wait"}, Timeout: 200 * time.Millisecond},
+				},
+			},
+		},
+	}
+
+	start := time.Now()
+
+	recorder := NewRecorder()
+	require.Error(t, env.Client.Build(env.Ctx, graph, recorder))
+	require.Less(t, time.Since(start), 900*time.Millisecond)
+
+	require.Contains(t, recorder.Jobs[build.ID{'a'}].Error, "timed out")
+}
+
+func TestBuildTimeout(t *testing.T) {
+	env := newEnv(t, singleWorkerConfig)
+
+	graph := build.Graph{
+		Jobs: []build.Job{
+			{
+				ID:   build.ID{'a'},
+				Name: "sleep",
+				Cmds: []build.Cmd{{Exec: []string{"sleep", "10"}}},
+			},
+		},
+	}
+
+	start := time.Now()
+
+	err := env.Client.BuildWithOptions(env.Ctx, graph, client.BuildOptions{Timeout: 300 * time.Millisecond}, NewRecorder())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "build timed out after 300ms")
+	require.Less(t, time.Since(start), 3*time.Second)
+}
@@ -2,12 +2,46 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"gitlab.com/justnurik/distbuild/pkg/build"
 )
 
+// BuildMode задаёт, что делать с остальными джобами, когда один из джобов упал.
+type BuildMode string
+
+const (
+	// BuildModeFailFast отменяет весь билд при первой ошибке. Используется по умолчанию.
+	BuildModeFailFast BuildMode = "fail_fast"
+	// BuildModeKeepGoing собирает всё, что не зависит от упавших джобов.
+	// Джобы, зависящие от упавших, пропускаются и попадают в StatusUpdate.JobSkipped.
+	BuildModeKeepGoing BuildMode = "keep_going"
+)
+
+// ParseBuildMode разбирает режим сборки. Пустая строка означает BuildModeFailFast.
+func ParseBuildMode(s string) (BuildMode, error) {
+	switch mode := BuildMode(s); mode {
+	case "":
+		return BuildModeFailFast, nil
+	case BuildModeFailFast, BuildModeKeepGoing:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown build mode %q", s)
+	}
+}
+
 type BuildRequest struct {
 	Graph build.Graph
+
+	// Mode передаётся в query-параметре mode запроса /build.
+	Mode BuildMode
+
+	// Timeout ограничивает время исполнения всего билда. Нулевое значение означает, что
+	// используется ограничение координатора по умолчанию.
+	//
+	// Timeout передаётся в query-параметре timeout запроса /build.
+	Timeout time.Duration
 }
 
 type BuildStarted struct {
@@ -17,10 +51,19 @@ type BuildStarted struct {
 
 type StatusUpdate struct {
 	JobFinished   *JobResult
+	JobSkipped    *JobSkipped
 	BuildFailed   *BuildFailed
 	BuildFinished *BuildFinished
 }
 
+// JobSkipped сообщает, что джоб не запускался, потому что упала одна из его зависимостей.
+type JobSkipped struct {
+	ID build.ID
+
+	// FailedDep задаёт упавший джоб, из-за которого пропущен этот джоб.
+	FailedDep build.ID
+}
+
 type BuildFailed struct {
 	Error string
 }
@@ -30,8 +73,14 @@ type BuildFinished struct {
 
 type UploadDone struct{}
 
+// Cancel просит координатора остановить билд.
+type Cancel struct {
+	Reason string
+}
+
 type SignalRequest struct {
 	UploadDone *UploadDone
+	Cancel     *Cancel
 }
 
 type SignalResponse struct {
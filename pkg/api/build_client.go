@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"time"
 
 	"go.uber.org/zap"
@@ -36,7 +37,20 @@ func NewBuildClient(l *zap.Logger, endpoint string) *BuildClient {
 
 func (c *BuildClient) StartBuild(ctx context.Context, request *BuildRequest) (*BuildStarted, StatusReader, error) {
 
-	resp, err := doRequest(c.l, &c.client, ctx, request.Graph, c.endpoint+"/build")
+	query := neturl.Values{}
+	if request.Mode != "" {
+		query.Set("mode", string(request.Mode))
+	}
+	if request.Timeout != 0 {
+		query.Set("timeout", request.Timeout.String())
+	}
+
+	url := c.endpoint + "/build"
+	if len(query) != 0 {
+		url += "?" + query.Encode()
+	}
+
+	resp, err := doRequest(c.l, &c.client, ctx, request.Graph, url)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -51,6 +65,13 @@ func (c *BuildClient) StartBuild(ctx context.Context, request *BuildRequest) (*B
 			return nil, nil, fmt.Errorf("read error body: %w", err)
 		}
 
+		var validationErr build.ValidationError
+		if resp.StatusCode == http.StatusBadRequest &&
+			json.Unmarshal(body, &validationErr) == nil && len(validationErr.Problems) != 0 {
+			c.l.Error("build graph rejected", zap.Error(&validationErr))
+			return nil, nil, &validationErr
+		}
+
 		c.l.Error("unexpected status",
 			zap.Int("status", resp.StatusCode),
 			zap.ByteString("body", body))
@@ -72,8 +93,17 @@ func (c *BuildClient) StartBuild(ctx context.Context, request *BuildRequest) (*B
 }
 
 func (c *BuildClient) SignalBuild(ctx context.Context, buildID build.ID, signal *SignalRequest) (*SignalResponse, error) {
+	return c.signal(ctx, signal, fmt.Sprintf("%s/signal?build_id=%s", c.endpoint, buildID.String()))
+}
+
+// CancelBuild просит координатора остановить билд.
+func (c *BuildClient) CancelBuild(ctx context.Context, buildID build.ID, reason string) error {
+	_, err := c.signal(ctx, &Cancel{Reason: reason}, fmt.Sprintf("%s/cancel?build_id=%s", c.endpoint, buildID.String()))
+	return err
+}
 
-	resp, err := doRequest(c.l, &c.client, ctx, signal, fmt.Sprintf("%s/signal?build_id=%s", c.endpoint, buildID.String()))
+func (c *BuildClient) signal(ctx context.Context, request any, url string) (*SignalResponse, error) {
+	resp, err := doRequest(c.l, &c.client, ctx, request, url)
 	if err != nil {
 		return nil, err
 	}
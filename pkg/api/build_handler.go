@@ -4,8 +4,10 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"gitlab.com/justnurik/distbuild/pkg/build"
 	"go.uber.org/zap"
@@ -26,11 +28,29 @@ func NewBuildService(l *zap.Logger, s Service) *BuildHandler {
 func (h *BuildHandler) Register(mux *http.ServeMux) {
 	mux.HandleFunc("/build", h.startBuildHandler)
 	mux.HandleFunc("/signal", h.signalBuildHandler)
+	mux.HandleFunc("/cancel", h.cancelBuildHandler)
 }
 
 func (h *BuildHandler) startBuildHandler(w http.ResponseWriter, r *http.Request) {
 
-	buildRequest := &BuildRequest{}
+	mode, err := ParseBuildMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		h.l.Error("invalid build mode", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var timeout time.Duration
+	if rawTimeout := r.URL.Query().Get("timeout"); rawTimeout != "" {
+		timeout, err = time.ParseDuration(rawTimeout)
+		if err != nil || timeout < 0 {
+			h.l.Error("invalid build timeout", zap.String("timeout", rawTimeout), zap.Error(err))
+			http.Error(w, fmt.Sprintf("invalid build timeout %q", rawTimeout), http.StatusBadRequest)
+			return
+		}
+	}
+
+	buildRequest := &BuildRequest{Mode: mode, Timeout: timeout}
 	if err := json.NewDecoder(r.Body).Decode(&buildRequest.Graph); err != nil {
 		h.l.Error("request body decoding error",
 			zap.Error(err))
@@ -40,7 +60,7 @@ func (h *BuildHandler) startBuildHandler(w http.ResponseWriter, r *http.Request)
 
 	sw := NewStatusWriter(h.l, w)
 
-	err := h.s.StartBuild(r.Context(), buildRequest, sw)
+	err = h.s.StartBuild(r.Context(), buildRequest, sw)
 	if err != nil {
 		h.l.Error("error on the coordinator's side: build execution error",
 			zap.Error(err),
@@ -62,6 +82,16 @@ func (h *BuildHandler) startBuildHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		var validationErr *build.ValidationError
+		if errors.As(err, &validationErr) {
+			h.l.Error("build graph rejected", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			if err := json.NewEncoder(w).Encode(validationErr); err != nil {
+				h.l.Error("couldn't send validation error", zap.Error(err))
+			}
+			return
+		}
+
 		h.l.Error("error before streaming started", zap.Error(err))
 		http.Error(w, fmt.Errorf("error before streaming started: %w", err).Error(), http.StatusInternalServerError)
 		return
@@ -71,26 +101,39 @@ func (h *BuildHandler) startBuildHandler(w http.ResponseWriter, r *http.Request)
 	done := sw.done
 	sw.mu.Unlock()
 
-	<-done
-	h.l.Info("successful start of the build", zap.Any("graph", buildRequest.Graph))
+	select {
+	case <-done:
+		h.l.Info("successful start of the build", zap.Any("graph", buildRequest.Graph))
+	case <-r.Context().Done():
+		h.l.Warn("client disconnected before the build finished", zap.Error(r.Context().Err()))
+	}
 }
 
-func (h *BuildHandler) signalBuildHandler(w http.ResponseWriter, r *http.Request) {
+func (h *BuildHandler) buildID(w http.ResponseWriter, r *http.Request) (build.ID, bool) {
+	var buildID build.ID
 
 	strID := r.URL.Query().Get("build_id")
 	if strID == "" {
 		h.l.Error("build_id не указан")
 		http.Error(w, "build id required", http.StatusBadRequest)
-		return
+		return buildID, false
 	}
 
-	var buildID build.ID
 	if err := buildID.UnmarshalText([]byte(strID)); err != nil {
 		h.l.Error("invalid build id",
 			zap.String("id", strID),
 			zap.Error(err),
 		)
 		http.Error(w, "invalid build id", http.StatusBadRequest)
+		return buildID, false
+	}
+
+	return buildID, true
+}
+
+func (h *BuildHandler) signalBuildHandler(w http.ResponseWriter, r *http.Request) {
+	buildID, ok := h.buildID(w, r)
+	if !ok {
 		return
 	}
 
@@ -112,3 +155,28 @@ func (h *BuildHandler) signalBuildHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 }
+
+func (h *BuildHandler) cancelBuildHandler(w http.ResponseWriter, r *http.Request) {
+	buildID, ok := h.buildID(w, r)
+	if !ok {
+		return
+	}
+
+	cancel := &Cancel{}
+	if err := json.NewDecoder(r.Body).Decode(cancel); err != nil {
+		h.l.Error("request body decoding error",
+			zap.Error(err),
+			zap.String("build_id", buildID.String()))
+		http.Error(w, fmt.Sprintf("request body decoding error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.s.SignalBuild(r.Context(), buildID, &SignalRequest{Cancel: cancel})
+	if err != nil {
+		h.l.Error("error on the coordinator's side: cancel execution error",
+			zap.Error(err),
+			zap.String("build_id", buildID.String()))
+		http.Error(w, fmt.Errorf("error on the coordinator's side: cancel execution error: %w", err).Error(), http.StatusInternalServerError)
+		return
+	}
+}
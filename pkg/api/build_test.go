@@ -2,11 +2,13 @@ package api_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
@@ -71,6 +73,25 @@ func TestBuildSignal(t *testing.T) {
 	require.Contains(t, err.Error(), "foo bar error")
 }
 
+func TestBuildCancel(t *testing.T) {
+	env, stop := newEnv(t)
+	defer stop()
+
+	ctx := context.Background()
+
+	buildID := build.ID{01}
+	req := &api.SignalRequest{Cancel: &api.Cancel{Reason: "not needed"}}
+
+	env.mock.EXPECT().SignalBuild(gomock.Any(), buildID, req).Return(&api.SignalResponse{}, nil)
+	env.mock.EXPECT().SignalBuild(gomock.Any(), buildID, req).Return(nil, fmt.Errorf("unknown build"))
+
+	require.NoError(t, env.client.CancelBuild(ctx, buildID, "not needed"))
+
+	err := env.client.CancelBuild(ctx, buildID, "not needed")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown build")
+}
+
 func TestBuildStartError(t *testing.T) {
 	env, stop := newEnv(t)
 	defer stop()
@@ -84,6 +105,48 @@ func TestBuildStartError(t *testing.T) {
 	require.Contains(t, err.Error(), "foo bar error")
 }
 
+func TestBuildStartOptions(t *testing.T) {
+	env, stop := newEnv(t)
+	defer stop()
+
+	ctx := context.Background()
+
+	req := &api.BuildRequest{Mode: api.BuildModeKeepGoing, Timeout: 90 * time.Second}
+
+	env.mock.EXPECT().StartBuild(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, actual *api.BuildRequest, _ api.StatusWriter) error {
+			require.Equal(t, api.BuildModeKeepGoing, actual.Mode)
+			require.Equal(t, 90*time.Second, actual.Timeout)
+			return fmt.Errorf("foo bar error")
+		})
+
+	_, _, err := env.client.StartBuild(ctx, req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "foo bar error")
+}
+
+func TestBuildStartValidationError(t *testing.T) {
+	env, stop := newEnv(t)
+	defer stop()
+
+	ctx := context.Background()
+
+	validationErr := &build.ValidationError{
+		Problems: []build.Problem{
+			{Kind: build.ProblemCycle, Job: build.ID{'a'}, Cycle: []build.ID{{'a'}, {'a'}}},
+		},
+	}
+
+	env.mock.EXPECT().StartBuild(gomock.Any(), gomock.Any(), gomock.Any()).Return(validationErr)
+
+	_, _, err := env.client.StartBuild(ctx, &api.BuildRequest{})
+	require.Error(t, err)
+
+	var actual *build.ValidationError
+	require.True(t, errors.As(err, &actual))
+	require.Equal(t, validationErr, actual)
+}
+
 func TestBuildRunning(t *testing.T) {
 	env, stop := newEnv(t)
 	defer stop()
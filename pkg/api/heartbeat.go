@@ -1,7 +1,11 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha1"
+	"slices"
+	"time"
 
 	"gitlab.com/justnurik/distbuild/pkg/build"
 )
@@ -18,6 +22,27 @@ type JobResult struct {
 	//
 	// Если Error == nil, значит джоб завершился успешно.
 	Error *string
+
+	// TimedOut выставляется, если джоб упал, потому что не уложился в таймаут
+	// (build.Cmd.Timeout или build.Limits.WallTime).
+	TimedOut bool
+
+	// Resources описывает ресурсы, потраченные на исполнение джоба.
+	// Равно nil, если джоб не исполнялся, например был взят из кеша.
+	Resources *ResourceUsage
+}
+
+// ResourceUsage описывает ресурсы, потраченные джобом на воркере.
+type ResourceUsage struct {
+	// UserTime и SystemTime - процессорное время всех команд джоба.
+	UserTime   time.Duration
+	SystemTime time.Duration
+
+	// MaxRSS - пиковое потребление памяти самой прожорливой командой, в байтах.
+	MaxRSS int64
+
+	// BytesWritten - суммарный размер stdout, stderr и выходной директории, в байтах.
+	BytesWritten int64
 }
 
 type WorkerID string
@@ -43,6 +68,47 @@ type HeartbeatRequest struct {
 
 	// AddedArtifacts говорит, какие артефакты появились в кеше на этой итерации цикла.
 	AddedArtifacts []build.ID
+
+	// RemovedArtifacts говорит, какие артефакты были вытеснены из кеша на этой итерации цикла.
+	RemovedArtifacts []build.ID
+
+	// RemovedFiles говорит, какие исходные файлы были вытеснены из кеша файлов. Файлы, которые к моменту
+	// отправки снова появились в кеше, сюда не попадают.
+	RemovedFiles []build.ID
+
+	// Inventory описывает содержимое кеша артефактов воркера. Воркер прикладывает его к первому
+	// heartbeat-у, периодически после этого и по просьбе координатора (HeartbeatResponse.ResyncArtifacts).
+	Inventory *ArtifactInventory
+}
+
+// ArtifactInventory описывает содержимое кеша артефактов воркера.
+type ArtifactInventory struct {
+	// Digest - дайджест всех артефактов в кеше, см. InventoryDigest.
+	Digest build.ID
+
+	// Full сообщает, что Artifacts содержит полный список артефактов в кеше.
+	// Иначе воркер прислал только Digest.
+	Full bool
+
+	Artifacts []build.ID
+}
+
+// InventoryDigest возвращает дайджест набора артефактов. Дайджест не зависит от порядка и повторов.
+func InventoryDigest(artifacts []build.ID) build.ID {
+	sorted := slices.Clone(artifacts)
+	slices.SortFunc(sorted, func(a, b build.ID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	sorted = slices.Compact(sorted)
+
+	h := sha1.New()
+	for _, id := range sorted {
+		_, _ = h.Write(id[:])
+	}
+
+	var digest build.ID
+	copy(digest[:], h.Sum(nil))
+	return digest
 }
 
 // JobSpec описывает джоб, который нужно запустить.
@@ -58,6 +124,15 @@ type JobSpec struct {
 
 type HeartbeatResponse struct {
 	JobsToRun map[build.ID]JobSpec
+
+	// CancelJobs перечисляет джобы, которые воркер должен остановить. Результаты этих джобов
+	// координатору больше не нужны.
+	CancelJobs []build.ID
+
+	// ResyncArtifacts просит воркер прислать полный список артефактов в своём кеше: координатор не знает,
+	// что в нём лежит, или дайджест из HeartbeatRequest.Inventory не совпал. Пока воркер не пришлёт
+	// список, координатор не выдаёт ему джобы.
+	ResyncArtifacts bool
 }
 
 type HeartbeatService interface {
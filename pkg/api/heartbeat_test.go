@@ -53,3 +53,11 @@ func TestHeartbeat(t *testing.T) {
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "build error: foo bar")
 }
+
+func TestInventoryDigest(t *testing.T) {
+	a, b := build.ID{'a'}, build.ID{'b'}
+
+	require.Equal(t, api.InventoryDigest([]build.ID{a, b}), api.InventoryDigest([]build.ID{b, a, b}))
+	require.NotEqual(t, api.InventoryDigest([]build.ID{a}), api.InventoryDigest([]build.ID{a, b}))
+	require.Equal(t, api.InventoryDigest(nil), api.InventoryDigest([]build.ID{}))
+}
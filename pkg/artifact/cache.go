@@ -1,6 +1,7 @@
 package artifact
 
 import (
+	"container/list"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -12,22 +13,40 @@ import (
 )
 
 var (
-	ErrNotFound    = errors.New("artifact not found")
-	ErrExists      = errors.New("artifact exists")
-	ErrWriteLocked = errors.New("artifact is locked for write")
-	ErrReadLocked  = errors.New("artifact is locked for read")
+	ErrNotFound       = errors.New("artifact not found")
+	ErrExists         = errors.New("artifact exists")
+	ErrWriteLocked    = errors.New("artifact is locked for write")
+	ErrReadLocked     = errors.New("artifact is locked for read")
+	ErrDigestMismatch = errors.New("artifact digest mismatch")
 )
 
 type Cache struct {
-	tmpDir   string
-	cacheDir string
+	tmpDir      string
+	cacheDir    string
+	metaDir     string
+	manifestDir string
+
+	config Config
 
 	mu          sync.Mutex
 	writeLocked map[build.ID]struct{}
 	readLocked  map[build.ID]int
+
+	// entries и lru упорядочивают артефакты по времени последнего использования: свежие в начале списка.
+	entries map[build.ID]*list.Element
+	lru     *list.List
+	size    int64
+
+	onEvict      func(artifact build.ID)
+	evicted      int64
+	evictedBytes int64
 }
 
 func NewCache(root string) (*Cache, error) {
+	return NewCacheWithConfig(root, Config{})
+}
+
+func NewCacheWithConfig(root string, config Config) (*Cache, error) {
 	tmpDir := filepath.Join(root, "tmp")
 
 	if err := os.RemoveAll(tmpDir); err != nil {
@@ -42,6 +61,16 @@ func NewCache(root string) (*Cache, error) {
 		return nil, err
 	}
 
+	metaDir := filepath.Join(root, "meta")
+	if err := os.MkdirAll(metaDir, 0777); err != nil {
+		return nil, err
+	}
+
+	manifestDir := filepath.Join(root, "manifest")
+	if err := os.MkdirAll(manifestDir, 0777); err != nil {
+		return nil, err
+	}
+
 	for i := 0; i < 256; i++ {
 		d := hex.EncodeToString([]byte{uint8(i)})
 		if err := os.MkdirAll(filepath.Join(cacheDir, d), 0777); err != nil {
@@ -49,12 +78,24 @@ func NewCache(root string) (*Cache, error) {
 		}
 	}
 
-	return &Cache{
+	c := &Cache{
 		tmpDir:      tmpDir,
 		cacheDir:    cacheDir,
+		metaDir:     metaDir,
+		manifestDir: manifestDir,
+		config:      config,
 		writeLocked: make(map[build.ID]struct{}),
 		readLocked:  make(map[build.ID]int),
-	}, nil
+		entries:     make(map[build.ID]*list.Element),
+		lru:         list.New(),
+	}
+
+	if err := c.loadEntries(); err != nil {
+		return nil, err
+	}
+	c.evict()
+
+	return c, nil
 }
 
 func (c *Cache) readLock(id build.ID) error {
@@ -136,15 +177,48 @@ func (c *Cache) Range(artifactFn func(artifact build.ID) error) error {
 }
 
 func (c *Cache) Remove(artifact build.ID) error {
+	_, _, err := c.remove(artifact)
+	return err
+}
+
+// remove удаляет артефакт и возвращает его размер. removed == false, если артефакта не было.
+func (c *Cache) remove(artifact build.ID) (size int64, removed bool, err error) {
 	if err := c.writeLock(artifact, true); err != nil {
-		return err
+		return 0, false, err
 	}
 	defer c.writeUnlock(artifact)
 
-	return os.RemoveAll(filepath.Join(c.cacheDir, artifact.Path()))
+	for _, path := range []string{c.metaPath(artifact), c.manifestPath(artifact)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return 0, false, err
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Join(c.cacheDir, artifact.Path())); err != nil {
+		return 0, false, err
+	}
+
+	size, removed = c.forget(artifact)
+	return size, removed, nil
 }
 
+// Create начинает запись артефакта. commit считает манифест артефакта (см. Manifest)
+// и переносит артефакт в кеш, abort отменяет запись.
 func (c *Cache) Create(artifact build.ID) (path string, commit, abort func() error, err error) {
+	path, commitManifest, abort, err := c.create(artifact)
+	if err != nil {
+		return
+	}
+
+	commit = func() error {
+		return commitManifest(nil)
+	}
+	return
+}
+
+// create работает как Create, но commit принимает уже проверенный манифест,
+// чтобы не считать его заново. Если манифест nil, commit считает его сам.
+func (c *Cache) create(artifact build.ID) (path string, commit func(m *Manifest) error, abort func() error, err error) {
 	if err = c.writeLock(artifact, false); err != nil {
 		return
 	}
@@ -160,9 +234,29 @@ func (c *Cache) Create(artifact build.ID) (path string, commit, abort func() err
 		return os.RemoveAll(path)
 	}
 
-	commit = func() error {
+	commit = func(m *Manifest) error {
 		defer c.writeUnlock(artifact)
-		return os.Rename(path, filepath.Join(c.cacheDir, artifact.Path()))
+
+		if m == nil {
+			computed, err := computeManifest(path)
+			if err != nil {
+				return err
+			}
+			m = &computed
+		}
+
+		// Манифест пишется до переноса артефакта, поэтому у артефакта в кеше он всегда есть.
+		if err := c.writeManifest(artifact, *m); err != nil {
+			return err
+		}
+
+		if err := os.Rename(path, filepath.Join(c.cacheDir, artifact.Path())); err != nil {
+			return err
+		}
+
+		c.add(artifact, m.Size)
+		c.evict()
+		return nil
 	}
 
 	return
@@ -183,6 +277,8 @@ func (c *Cache) Get(artifact build.ID) (path string, unlock func(), err error) {
 		return
 	}
 
+	c.touch(artifact, path)
+
 	unlock = func() {
 		c.readUnlock(artifact)
 	}
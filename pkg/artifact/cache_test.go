@@ -2,14 +2,18 @@ package artifact_test
 
 import (
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/justnurik/distbuild/pkg/artifact"
 	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/tarstream"
 )
 
 type testCache struct {
@@ -96,3 +100,197 @@ func TestArtifactExists(t *testing.T) {
 	_, _, _, err = c.Create(idA)
 	require.Truef(t, errors.Is(err, artifact.ErrExists), "%v", err)
 }
+
+func TestArtifactMeta(t *testing.T) {
+	c := newTestCache(t)
+
+	idA := build.ID{'a'}
+
+	_, err := c.ReadMeta(idA)
+	require.Truef(t, errors.Is(err, artifact.ErrNotFound), "%v", err)
+
+	_, commit, _, err := c.Create(idA)
+	require.NoError(t, err)
+	require.NoError(t, c.WriteMeta(idA, []byte("result")))
+	require.NoError(t, commit())
+
+	// Метаданные переживают перезапуск.
+	reopened, err := artifact.NewCache(c.tmpDir)
+	require.NoError(t, err)
+
+	meta, err := reopened.ReadMeta(idA)
+	require.NoError(t, err)
+	require.Equal(t, []byte("result"), meta)
+
+	require.NoError(t, reopened.Remove(idA))
+
+	_, err = reopened.ReadMeta(idA)
+	require.Truef(t, errors.Is(err, artifact.ErrNotFound), "%v", err)
+}
+
+func TestArtifactManifest(t *testing.T) {
+	c := newTestCache(t)
+
+	idA := build.ID{'a'}
+
+	path, commit, _, err := c.Create(idA)
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(path, "dir"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "dir", "x"), []byte("xxx"), 0666))
+	require.NoError(t, os.Symlink("dir/x", filepath.Join(path, "link")))
+	require.NoError(t, commit())
+
+	path, unlock, err := c.Get(idA)
+	require.NoError(t, err)
+	defer unlock()
+
+	m, err := c.Manifest(idA)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), m.Size)
+	require.Equal(t, 3, m.Entries)
+
+	// Дайджест манифеста совпадает с дайджестом потока, которым передаётся артефакт.
+	var digest tarstream.Digest
+	require.NoError(t, tarstream.Send(path, io.Discard, tarstream.WithDigest(&digest)))
+	require.Equal(t, digest, m.Digest)
+}
+
+func writeArtifact(t *testing.T, c *artifact.Cache, id build.ID, size int) {
+	t.Helper()
+
+	path, commit, _, err := c.Create(id)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(path, "data"), make([]byte, size), 0666))
+	require.NoError(t, commit())
+}
+
+func hasArtifact(c *artifact.Cache, id build.ID) bool {
+	_, unlock, err := c.Get(id)
+	if err != nil {
+		return false
+	}
+	unlock()
+	return true
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	c, err := artifact.NewCacheWithConfig(tmpDir, artifact.Config{MaxSize: 25})
+	require.NoError(t, err)
+
+	var evicted []build.ID
+	c.OnEvict(func(id build.ID) { evicted = append(evicted, id) })
+
+	idA, idB, idC := build.ID{'a'}, build.ID{'b'}, build.ID{'c'}
+
+	writeArtifact(t, c, idA, 10)
+	writeArtifact(t, c, idB, 10)
+
+	// a использовали позже b, поэтому вытеснен будет b.
+	require.True(t, hasArtifact(c, idA))
+
+	writeArtifact(t, c, idC, 10)
+
+	require.Equal(t, []build.ID{idB}, evicted)
+	require.True(t, hasArtifact(c, idA))
+	require.False(t, hasArtifact(c, idB))
+	require.True(t, hasArtifact(c, idC))
+
+	require.Equal(t, artifact.Stats{
+		Artifacts:    2,
+		Size:         20,
+		MaxSize:      25,
+		Evicted:      1,
+		EvictedBytes: 10,
+	}, c.Stats())
+
+	// После перезапуска порядок использования сохраняется.
+	c, err = artifact.NewCacheWithConfig(tmpDir, artifact.Config{MaxSize: 15})
+	require.NoError(t, err)
+	require.True(t, hasArtifact(c, idC))
+	require.False(t, hasArtifact(c, idA))
+}
+
+func TestEvictRespectsLocks(t *testing.T) {
+	c, err := artifact.NewCacheWithConfig(t.TempDir(), artifact.Config{MaxSize: 15})
+	require.NoError(t, err)
+
+	idA, idB := build.ID{'a'}, build.ID{'b'}
+
+	writeArtifact(t, c, idA, 10)
+
+	_, unlock, err := c.Get(idA)
+	require.NoError(t, err)
+
+	// a читают, поэтому кеш временно превышает бюджет.
+	writeArtifact(t, c, idB, 10)
+	require.True(t, hasArtifact(c, idA))
+	require.True(t, hasArtifact(c, idB))
+
+	unlock()
+}
+
+func TestEvictCanEvict(t *testing.T) {
+	idA, idB, idC := build.ID{'a'}, build.ID{'b'}, build.ID{'c'}
+
+	c, err := artifact.NewCacheWithConfig(t.TempDir(), artifact.Config{
+		MaxSize:  25,
+		CanEvict: func(id build.ID) bool { return id != idA },
+	})
+	require.NoError(t, err)
+
+	writeArtifact(t, c, idA, 10)
+	writeArtifact(t, c, idB, 10)
+	writeArtifact(t, c, idC, 10)
+
+	require.True(t, hasArtifact(c, idA))
+	require.False(t, hasArtifact(c, idB))
+	require.True(t, hasArtifact(c, idC))
+}
+
+func TestEvictLock(t *testing.T) {
+	idA, idB := build.ID{'a'}, build.ID{'b'}
+
+	var (
+		mu     sync.Mutex
+		pinned = make(map[build.ID]bool)
+		c      *artifact.Cache
+	)
+
+	// found получает, нашёлся ли артефакт в кеше сразу после того, как его закрепили.
+	found := make(chan bool, 1)
+
+	c, err := artifact.NewCacheWithConfig(t.TempDir(), artifact.Config{
+		MaxSize: 15,
+		CanEvict: func(id build.ID) bool {
+			canEvict := !pinned[id]
+
+			// Артефакт закрепляют, пока кеш решает, вытеснять ли его.
+			go func() {
+				mu.Lock()
+				pinned[id] = true
+				mu.Unlock()
+
+				found <- hasArtifact(c, id)
+			}()
+
+			select {
+			case ok := <-found:
+				found <- ok
+			case <-time.After(50 * time.Millisecond):
+			}
+			return canEvict
+		},
+		EvictLock: &mu,
+	})
+	require.NoError(t, err)
+
+	writeArtifact(t, c, idA, 10)
+	writeArtifact(t, c, idB, 10)
+
+	// Закрепление ждёт, пока артефакт вытесняется, и уже не находит его.
+	require.False(t, <-found)
+	require.False(t, hasArtifact(c, idA))
+	require.True(t, hasArtifact(c, idB))
+}
@@ -4,22 +4,32 @@ package artifact
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 
 	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/codec"
 	"gitlab.com/justnurik/distbuild/pkg/tarstream"
 )
 
 // Download artifact from remote cache into local cache.
-func Download(ctx context.Context, endpoint string, c *Cache, artifactID build.ID) error {
+//
+// Принятый артефакт сверяется с манифестом, который прислал Handler: до commit проверяется дайджест
+// принятого потока, а размер и число записей ограничивают распаковку. При расхождении
+// артефакт не попадает в кеш и возвращается ErrDigestMismatch.
+//
+// Артефакт приходит от другого воркера, поэтому opts обычно задают ограничения
+// tarstream.WithMaxBytes и tarstream.WithMaxEntries.
+func Download(ctx context.Context, endpoint string, c *Cache, artifactID build.ID, opts ...tarstream.Option) error {
 	url := fmt.Sprintf("%s/artifact?id=%s", endpoint, artifactID.String())
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return fmt.Errorf("error creating GET %s request: %w", url, err)
 	}
+	req.Header.Set("Accept-Encoding", codec.AcceptEncoding())
 
 	client := http.Client{}
 	resp, err := client.Do(req)
@@ -33,19 +43,57 @@ func Download(ctx context.Context, endpoint string, c *Cache, artifactID build.I
 		return fmt.Errorf("unexpected status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	path, commit, abort, err := c.Create(artifactID)
+	var digest tarstream.Digest
+	manifest, err := parseManifest(resp.Header.Get(manifestHeader))
+	if err != nil {
+		return fmt.Errorf("verify artifact %s: %w", artifactID, err)
+	}
+
+	body, err := codec.DecodeResponse(resp)
+	if err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	defer func() { _ = body.Close() }()
+
+	path, commit, abort, err := c.create(artifactID)
 	if err != nil {
 		return fmt.Errorf("create cache entry: %w", err)
 	}
 
-	if err := tarstream.Receive(path, resp.Body); err != nil {
+	// Манифест ограничивает распаковку: поток, который не уложился в него, всё равно будет отвергнут.
+	opts = append(opts,
+		tarstream.WithMaxBytes(manifest.Size),
+		tarstream.WithMaxEntries(manifest.Entries),
+		tarstream.WithDigest(&digest))
+
+	if err := tarstream.Receive(path, body, opts...); err != nil {
 		abortErr := abort()
 		return fmt.Errorf("receive tar stream: %w, abort err: %w", err, abortErr)
 	}
 
-	if err := commit(); err != nil {
+	if digest != manifest.Digest {
+		abortErr := abort()
+		return fmt.Errorf("verify artifact %s: %w: manifest %s, received %s, abort err: %w",
+			artifactID, ErrDigestMismatch, manifest.Digest, digest, abortErr)
+	}
+
+	// Принятый артефакт совпал с манифестом, поэтому commit не пересчитывает его.
+	if err := commit(&manifest); err != nil {
 		return fmt.Errorf("commit artifact: %w", err)
 	}
 
 	return nil
 }
+
+func parseManifest(header string) (Manifest, error) {
+	var m Manifest
+	if header == "" {
+		return m, fmt.Errorf("%w: %s header is missing", ErrDigestMismatch, manifestHeader)
+	}
+
+	if err := json.Unmarshal([]byte(header), &m); err != nil {
+		return m, fmt.Errorf("%w: invalid %s header: %w", ErrDigestMismatch, manifestHeader, err)
+	}
+
+	return m, nil
+}
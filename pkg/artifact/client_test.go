@@ -2,6 +2,7 @@ package artifact_test
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -13,6 +14,7 @@ import (
 
 	"gitlab.com/justnurik/distbuild/pkg/artifact"
 	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/tarstream"
 )
 
 func TestArtifactTransfer(t *testing.T) {
@@ -24,6 +26,9 @@ func TestArtifactTransfer(t *testing.T) {
 	dir, commit, _, err := remoteCache.Create(id)
 	require.NoError(t, err)
 	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("foobar"), 0777))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "private"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "private", "key"), []byte("secret"), 0600))
+	require.NoError(t, os.Link(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")))
 	require.NoError(t, commit())
 
 	l := zaptest.NewLogger(t)
@@ -46,6 +51,86 @@ func TestArtifactTransfer(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []byte("foobar"), content)
 
+	// Права и жёсткие ссылки передаются как есть.
+	for path, mode := range map[string]os.FileMode{"private": os.ModeDir | 0700, "private/key": 0600} {
+		info, err := os.Stat(filepath.Join(dir, path))
+		require.NoError(t, err)
+		require.Equal(t, mode, info.Mode(), path)
+	}
+
+	a, err := os.Stat(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	b, err := os.Stat(filepath.Join(dir, "b.txt"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(a, b))
+
 	err = artifact.Download(ctx, server.URL, localCache.Cache, build.ID{0x02})
 	require.Error(t, err)
 }
+
+func TestArtifactTransferDigestMismatch(t *testing.T) {
+	localCache := newTestCache(t)
+
+	from := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(from, "a.txt"), []byte("foobar"), 0644))
+
+	for name, manifest := range map[string]*artifact.Manifest{
+		"Missing":     nil,
+		"WrongDigest": {Size: 6, Entries: 1},
+		"TooLarge":    {Size: 3, Entries: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if manifest != nil {
+					header, err := json.Marshal(manifest)
+					require.NoError(t, err)
+					w.Header().Set("X-Artifact-Manifest", string(header))
+				}
+				_ = tarstream.Send(from, w)
+			}))
+			defer server.Close()
+
+			id := build.ID{0x01}
+
+			err := artifact.Download(context.Background(), server.URL, localCache.Cache, id)
+			if name == "TooLarge" {
+				require.ErrorIs(t, err, tarstream.ErrTooLarge)
+			} else {
+				require.ErrorIs(t, err, artifact.ErrDigestMismatch)
+			}
+
+			_, _, err = localCache.Get(id)
+			require.ErrorIs(t, err, artifact.ErrNotFound)
+		})
+	}
+}
+
+func TestArtifactTransferCorruptedSource(t *testing.T) {
+	remoteCache := newTestCache(t)
+	localCache := newTestCache(t)
+
+	id := build.ID{0x01}
+
+	dir, commit, _, err := remoteCache.Create(id)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("foobar"), 0644))
+	require.NoError(t, commit())
+
+	// Артефакт испортился на диске после commit.
+	dir, unlock, err := remoteCache.Get(id)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("fooBar"), 0644))
+	unlock()
+
+	mux := http.NewServeMux()
+	artifact.NewHandler(zaptest.NewLogger(t), remoteCache.Cache).Register(mux)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	err = artifact.Download(context.Background(), server.URL, localCache.Cache, id)
+	require.ErrorIs(t, err, artifact.ErrDigestMismatch)
+
+	_, _, err = localCache.Get(id)
+	require.ErrorIs(t, err, artifact.ErrNotFound)
+}
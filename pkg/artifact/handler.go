@@ -3,14 +3,19 @@
 package artifact
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/codec"
 	"gitlab.com/justnurik/distbuild/pkg/tarstream"
 	"go.uber.org/zap"
 )
 
+// manifestHeader - HTTP заголовок с Manifest отправляемого артефакта в формате json.
+const manifestHeader = "X-Artifact-Manifest"
+
 type Handler struct {
 	l *zap.Logger
 	c *Cache
@@ -57,11 +62,54 @@ func (h *Handler) Register(mux *http.ServeMux) {
 		}
 		defer unlock()
 
-		if err := tarstream.Send(path, w); err != nil {
+		manifest, err := h.c.Manifest(artifactID)
+		if err != nil {
+			h.l.Error("failed to get artifact manifest",
+				zap.String("id", strID),
+				zap.Error(err))
+			http.Error(w, fmt.Errorf("failed to get artifact manifest: %w", err).Error(), http.StatusInternalServerError)
+			return
+		}
+
+		header, err := json.Marshal(manifest)
+		if err != nil {
+			h.l.Error("failed to encode artifact manifest", zap.Error(err))
+			http.Error(w, fmt.Errorf("failed to encode artifact manifest: %w", err).Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(manifestHeader, string(header))
+
+		body, err := codec.EncodeResponse(w, r)
+		if err != nil {
+			h.l.Error("failed to create encoder", zap.Error(err))
+			http.Error(w, fmt.Errorf("failed to create encoder: %w", err).Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var digest tarstream.Digest
+		if err := tarstream.Send(path, body, tarstream.WithDigest(&digest)); err != nil {
 			h.l.Error("failed to send artifact",
 				zap.String("id", strID),
 				zap.Error(err))
 			http.Error(w, fmt.Errorf("failed to send artifact: %w", err).Error(), http.StatusBadRequest)
+			return
 		}
+
+		if err := body.Close(); err != nil {
+			h.l.Error("failed to send artifact",
+				zap.String("id", strID),
+				zap.Error(err))
+			return
+		}
+
+		// Получатель отвергнет такой артефакт сам, здесь расхождение только логируется.
+		if digest != manifest.Digest {
+			h.l.Error("artifact does not match its manifest",
+				zap.String("id", strID),
+				zap.String("manifest_digest", manifest.Digest.String()),
+				zap.String("digest", digest.String()))
+		}
+
+		body.Stats().Log(h.l, "artifact sent", zap.String("id", strID))
 	})
 }
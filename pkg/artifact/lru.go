@@ -0,0 +1,238 @@
+package artifact
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// Config задаёт параметры кеша.
+type Config struct {
+	// MaxSize ограничивает суммарный размер артефактов в байтах. Когда кеш его превышает,
+	// давно не использованные артефакты вытесняются. Если MaxSize == 0, кеш не ограничен.
+	MaxSize int64
+
+	// CanEvict, если задан, может запретить вытеснение артефакта.
+	CanEvict func(artifact build.ID) bool
+
+	// EvictLock, если задан, держится, пока кеш вызывает CanEvict и удаляет разрешённый артефакт. Так решение
+	// CanEvict не устаревает до удаления: код, который под тем же локом меняет то, что проверяет CanEvict,
+	// видит артефакт либо ещё на месте и защищённым, либо уже удалённым. CanEvict не должен брать EvictLock сам.
+	EvictLock sync.Locker
+}
+
+// Stats описывает заполненность кеша и вытеснения с момента его открытия.
+type Stats struct {
+	Artifacts int
+	Size      int64
+	MaxSize   int64
+
+	Evicted      int64
+	EvictedBytes int64
+}
+
+// entry описывает артефакт в списке LRU. Защищено Cache.mu.
+type entry struct {
+	id         build.ID
+	size       int64
+	lastAccess time.Time
+}
+
+// OnEvict задаёт функцию, которую кеш вызывает после вытеснения каждого артефакта.
+func (c *Cache) OnEvict(fn func(artifact build.ID)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onEvict = fn
+}
+
+// Stats возвращает текущую статистику кеша.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Artifacts:    len(c.entries),
+		Size:         c.size,
+		MaxSize:      c.config.MaxSize,
+		Evicted:      c.evicted,
+		EvictedBytes: c.evictedBytes,
+	}
+}
+
+// loadEntries заполняет список LRU артефактами, которые уже лежат на диске. Время последнего
+// использования артефакта хранится в mtime его директории, поэтому порядок переживает перезапуск.
+func (c *Cache) loadEntries() error {
+	var entries []*entry
+	err := c.Range(func(artifact build.ID) error {
+		path := filepath.Join(c.cacheDir, artifact.Path())
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		size, err := dirSize(path)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, &entry{id: artifact, size: size, lastAccess: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastAccess.Before(entries[j].lastAccess)
+	})
+
+	for _, e := range entries {
+		c.entries[e.id] = c.lru.PushFront(e)
+		c.size += e.size
+	}
+
+	return nil
+}
+
+// add учитывает только что записанный артефакт.
+func (c *Cache) add(artifact build.ID, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[artifact] = c.lru.PushFront(&entry{id: artifact, size: size, lastAccess: time.Now()})
+	c.size += size
+}
+
+// forget убирает артефакт из учёта и возвращает его размер. ok == false, если артефакт не учитывался.
+func (c *Cache) forget(artifact build.ID) (size int64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[artifact]
+	if !ok {
+		return 0, false
+	}
+
+	size = elem.Value.(*entry).size
+	c.lru.Remove(elem)
+	delete(c.entries, artifact)
+	c.size -= size
+	return size, true
+}
+
+// touch отмечает использование артефакта.
+func (c *Cache) touch(artifact build.ID, path string) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if elem, ok := c.entries[artifact]; ok {
+		elem.Value.(*entry).lastAccess = now
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+
+	// Ошибка не критична: после перезапуска артефакт просто окажется старше, чем на самом деле.
+	_ = os.Chtimes(path, now, now)
+}
+
+// evict вытесняет давно не использованные артефакты, пока кеш не уложится в MaxSize.
+//
+// Артефакты, на которые взят лок (в том числе только что записанный), и артефакты,
+// которые запретил CanEvict, не вытесняются.
+func (c *Cache) evict() {
+	if c.config.MaxSize <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if c.size <= c.config.MaxSize {
+		c.mu.Unlock()
+		return
+	}
+
+	var candidates []build.ID
+	for elem := c.lru.Back(); elem != nil; elem = elem.Prev() {
+		id := elem.Value.(*entry).id
+		if _, locked := c.writeLocked[id]; locked || c.readLocked[id] > 0 {
+			continue
+		}
+		candidates = append(candidates, id)
+	}
+	onEvict := c.onEvict
+	c.mu.Unlock()
+
+	for _, id := range candidates {
+		if !c.overBudget() {
+			return
+		}
+
+		size, removed := c.tryEvict(id)
+		if !removed {
+			continue
+		}
+
+		c.mu.Lock()
+		c.evicted++
+		c.evictedBytes += size
+		c.mu.Unlock()
+
+		if onEvict != nil {
+			onEvict(id)
+		}
+	}
+}
+
+// tryEvict удаляет артефакт, если его разрешает вытеснить CanEvict, и возвращает размер удалённого артефакта.
+func (c *Cache) tryEvict(artifact build.ID) (size int64, removed bool) {
+	if c.config.EvictLock != nil {
+		c.config.EvictLock.Lock()
+		defer c.config.EvictLock.Unlock()
+	}
+
+	if c.config.CanEvict != nil && !c.config.CanEvict(artifact) {
+		return 0, false
+	}
+
+	// Пока мы выбирали кандидатов, артефакт могли начать читать: тогда Remove вернёт ErrReadLocked.
+	size, removed, err := c.remove(artifact)
+	if err != nil {
+		return 0, false
+	}
+	return size, removed
+}
+
+func (c *Cache) overBudget() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size > c.config.MaxSize
+}
+
+// dirSize возвращает суммарный размер файлов в директории.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,107 @@
+package artifact
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/tarstream"
+)
+
+// Manifest описывает содержимое артефакта. Кеш считает его при commit и хранит рядом с артефактом,
+// Handler передаёт его вместе с артефактом, а Download сверяет с ним принятое содержимое.
+type Manifest struct {
+	// Version - версия способа, которым посчитан Digest.
+	Version int
+
+	// Digest - tarstream.Digest потока, которым Handler передаёт артефакт. Поток переносит права
+	// и жёсткие ссылки, поэтому Digest меняется и при их изменении.
+	Digest tarstream.Digest
+
+	// Size - суммарный размер файлов, Entries - число записей в потоке.
+	Size    int64
+	Entries int
+}
+
+// manifestVersion меняется вместе с опциями, с которыми Handler передаёт артефакты.
+// Сохранённые манифесты других версий пересчитываются.
+const manifestVersion = 1
+
+// Manifest возвращает манифест артефакта. Вызывающий должен держать артефакт на чтение (см. Get).
+//
+// У артефактов, записанных до появления манифестов или с манифестом другой версии, манифест считается
+// при первом обращении.
+func (c *Cache) Manifest(artifact build.ID) (Manifest, error) {
+	var m Manifest
+
+	data, err := os.ReadFile(c.manifestPath(artifact))
+	if err == nil {
+		if err := json.Unmarshal(data, &m); err != nil {
+			return m, err
+		}
+		if m.Version == manifestVersion {
+			return m, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return m, err
+	}
+
+	m, err = computeManifest(filepath.Join(c.cacheDir, artifact.Path()))
+	if err != nil {
+		return m, err
+	}
+
+	return m, c.writeManifest(artifact, m)
+}
+
+func (c *Cache) writeManifest(artifact build.ID, m Manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return c.writeFile(c.manifestPath(artifact), data)
+}
+
+func (c *Cache) manifestPath(artifact build.ID) string {
+	return filepath.Join(c.manifestDir, artifact.Path())
+}
+
+// computeManifest считает манифест директории.
+func computeManifest(path string) (Manifest, error) {
+	m := Manifest{Version: manifestVersion}
+
+	err := filepath.WalkDir(path, func(entryPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entryPath == path {
+			return nil
+		}
+
+		m.Entries++
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		m.Size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+
+	if err := tarstream.Send(path, io.Discard, tarstream.WithDigest(&m.Digest)); err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,52 @@
+package artifact
+
+import (
+	"os"
+	"path/filepath"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// WriteMeta сохраняет метаданные артефакта, например результат джоба, который его создал.
+//
+// Метаданные хранятся на диске отдельно от содержимого артефакта, переживают перезапуск
+// и удаляются вместе с артефактом.
+func (c *Cache) WriteMeta(artifact build.ID, meta []byte) error {
+	return c.writeFile(c.metaPath(artifact), meta)
+}
+
+// ReadMeta возвращает метаданные, сохранённые WriteMeta, или ErrNotFound.
+func (c *Cache) ReadMeta(artifact build.ID) ([]byte, error) {
+	meta, err := os.ReadFile(c.metaPath(artifact))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return meta, err
+}
+
+func (c *Cache) metaPath(artifact build.ID) string {
+	return filepath.Join(c.metaDir, artifact.Path())
+}
+
+// writeFile атомарно записывает служебный файл артефакта.
+func (c *Cache) writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(c.tmpDir, filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -60,6 +60,7 @@ func (c *Cmd) Render(ctx JobContext) (*Cmd, error) {
 	rendered.WorkingDirectory = render(c.WorkingDirectory)
 	rendered.Exec = renderList(c.Exec)
 	rendered.Environ = renderList(c.Environ)
+	rendered.Timeout = c.Timeout
 
 	if len(errs) != 0 {
 		return nil, fmt.Errorf("error rendering cmd: %w", errs[0])
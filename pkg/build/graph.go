@@ -1,10 +1,13 @@
 package build
 
+import "time"
+
 // Job описывает одну вершину графа сборки.
 type Job struct {
 	// ID задаёт уникальный идентификатор джоба.
 	//
-	// ID вычисляется как хеш от всех входных файлов, команд запуска и хешей зависимых джобов.
+	// ID вычисляется как хеш от всех входных файлов, команд запуска и хешей зависимых джобов
+	// (см. Graph.ComputeIDs).
 	//
 	// Выход джоба целиком определяется его ID. Это важное свойство позволяет кешировать
 	// результаты сборки.
@@ -29,6 +32,26 @@ type Job struct {
 
 	// Cmds описывает список команд, которые нужно выполнить в рамках этого джоба.
 	Cmds []Cmd
+
+	// Limits задаёт ограничения на ресурсы джоба. Ограничения не влияют на ID джоба.
+	Limits Limits
+}
+
+// Limits описывает ограничения на ресурсы, которые может потратить джоб.
+//
+// Нулевое значение поля означает, что ограничения нет.
+type Limits struct {
+	// CPUTime ограничивает суммарное процессорное время всех команд джоба и их потомков.
+	CPUTime time.Duration
+
+	// Memory ограничивает суммарный RSS процессов, запущенных одной командой джоба, в байтах.
+	Memory int64
+
+	// WallTime ограничивает время исполнения всех команд джоба, то есть задаёт таймаут джоба.
+	WallTime time.Duration
+
+	// Output ограничивает суммарный размер stdout, stderr и выходной директории джоба, в байтах.
+	Output int64
 }
 
 // Cmd описывает одну команду сборки.
@@ -61,6 +84,10 @@ type Cmd struct {
 
 	// CatOutput задаёт выходной файл для команды типа cat.
 	CatOutput string
+
+	// Timeout ограничивает время исполнения команды из Exec. Нулевое значение означает, что
+	// ограничения нет. Время всего джоба ограничивает Job.Limits.WallTime. Timeout не влияет на ID джоба.
+	Timeout time.Duration
 }
 
 type Graph struct {
@@ -0,0 +1,223 @@
+package build
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SourceFileID вычисляет id исходного файла path внутри sourceDir как хеш от пути и содержимого.
+//
+// Путь входит в хеш, чтобы файлы с одинаковым содержимым по разным путям получали разные id:
+// граф хранит исходные файлы в отображении из id в путь.
+func SourceFileID(sourceDir, path string) (ID, error) {
+	f, err := os.Open(filepath.Join(sourceDir, path))
+	if err != nil {
+		return ID{}, err
+	}
+	defer f.Close()
+
+	h := NewSourceFileHash(path)
+	if _, err := io.Copy(h, f); err != nil {
+		return ID{}, err
+	}
+
+	return h.ID(), nil
+}
+
+// SourceFileHash вычисляет SourceFileID по мере записи содержимого файла, например при передаче по сети.
+type SourceFileHash struct {
+	h jobHasher
+}
+
+// NewSourceFileHash начинает вычисление id исходного файла path. В хеш записывается содержимое файла.
+func NewSourceFileHash(path string) *SourceFileHash {
+	h := jobHasher{sha1.New()}
+	h.writeString(filepath.ToSlash(filepath.Clean(path)))
+	return &SourceFileHash{h: h}
+}
+
+func (h *SourceFileHash) Write(p []byte) (int, error) {
+	return h.h.Write(p)
+}
+
+// ID возвращает id файла с содержимым, записанным на данный момент.
+func (h *SourceFileHash) ID() ID {
+	return h.h.sum()
+}
+
+// ComputeIDs заполняет id исходных файлов и джобов графа.
+//
+// Id файла - хеш от его пути и содержимого (см. SourceFileID). Файлы ищутся внутри sourceDir. Все файлы из Inputs,
+// которых нет в SourceFiles, добавляются в SourceFiles.
+//
+// Id джоба - хеш от id и путей входных файлов, команд, переменных окружения и id зависимостей.
+// До вызова ComputeIDs id джобов используются только для того, чтобы ссылаться на зависимости,
+// поэтому достаточно, чтобы они были уникальными. Ссылки на зависимости в шаблонах команд
+// переписываются на новые id.
+func (g *Graph) ComputeIDs(sourceDir string) error {
+	fileIDs := make(map[string]ID)
+
+	addFile := func(path string) error {
+		if _, ok := fileIDs[path]; ok {
+			return nil
+		}
+
+		id, err := SourceFileID(sourceDir, path)
+		if err != nil {
+			return fmt.Errorf("compute id of %q: %w", path, err)
+		}
+
+		fileIDs[path] = id
+		return nil
+	}
+
+	for _, path := range g.SourceFiles {
+		if err := addFile(path); err != nil {
+			return err
+		}
+	}
+	for _, job := range g.Jobs {
+		for _, input := range job.Inputs {
+			if err := addFile(input); err != nil {
+				return err
+			}
+		}
+	}
+
+	jobIndex := make(map[ID]int, len(g.Jobs))
+	for i, job := range g.Jobs {
+		if _, ok := jobIndex[job.ID]; ok {
+			return fmt.Errorf("duplicate job id %s", job.ID)
+		}
+		jobIndex[job.ID] = i
+	}
+
+	for _, job := range g.Jobs {
+		for _, dep := range job.Deps {
+			if _, ok := jobIndex[dep]; !ok {
+				return fmt.Errorf("job %s depends on unknown job %s", job.ID, dep)
+			}
+		}
+	}
+
+	newIDs := make(map[ID]ID, len(g.Jobs))
+	jobs := make([]Job, len(g.Jobs))
+
+	for _, job := range TopSort(g.Jobs) {
+		hashed, err := hashJob(job, fileIDs, newIDs)
+		if err != nil {
+			return fmt.Errorf("compute id of job %q: %w", job.Name, err)
+		}
+
+		newIDs[job.ID] = hashed.ID
+		jobs[jobIndex[job.ID]] = hashed
+	}
+
+	g.Jobs = jobs
+	g.SourceFiles = make(map[ID]string, len(fileIDs))
+	for path, id := range fileIDs {
+		g.SourceFiles[id] = path
+	}
+
+	return nil
+}
+
+// hashJob возвращает копию джоба с вычисленным id, зависимостями и командами,
+// в которых ссылки на зависимости заменены на новые id.
+func hashJob(job Job, fileIDs map[string]ID, newIDs map[ID]ID) (Job, error) {
+	// Рендер с плейсхолдерами вместо путей приводит шаблоны к каноническому виду.
+	ctx := JobContext{
+		SourceDir: "{{.SourceDir}}",
+		OutputDir: "{{.OutputDir}}",
+		Deps:      make(map[ID]string, len(job.Deps)),
+	}
+
+	deps := make([]ID, 0, len(job.Deps))
+	for _, dep := range job.Deps {
+		newID := newIDs[dep]
+		ctx.Deps[dep] = fmt.Sprintf("{{index .Deps %q}}", newID)
+		deps = append(deps, newID)
+	}
+
+	cmds := make([]Cmd, 0, len(job.Cmds))
+	for i := range job.Cmds {
+		rendered, err := job.Cmds[i].Render(ctx)
+		if err != nil {
+			return Job{}, err
+		}
+		cmds = append(cmds, *rendered)
+	}
+
+	inputs := append([]string(nil), job.Inputs...)
+	sort.Strings(inputs)
+
+	sortedDeps := append([]ID(nil), deps...)
+	sort.Slice(sortedDeps, func(i, j int) bool {
+		return bytes.Compare(sortedDeps[i][:], sortedDeps[j][:]) < 0
+	})
+
+	h := jobHasher{sha1.New()}
+
+	h.writeInt(len(inputs))
+	for _, input := range inputs {
+		h.writeString(input)
+		h.writeID(fileIDs[input])
+	}
+
+	h.writeInt(len(cmds))
+	for _, cmd := range cmds {
+		h.writeStrings(cmd.Exec)
+		h.writeStrings(cmd.Environ)
+		h.writeString(cmd.WorkingDirectory)
+		h.writeString(cmd.CatTemplate)
+		h.writeString(cmd.CatOutput)
+	}
+
+	h.writeInt(len(sortedDeps))
+	for _, dep := range sortedDeps {
+		h.writeID(dep)
+	}
+
+	job.ID = h.sum()
+	job.Deps = deps
+	job.Cmds = cmds
+	return job, nil
+}
+
+type jobHasher struct {
+	hash.Hash
+}
+
+func (h jobHasher) writeInt(n int) {
+	var buf [binary.MaxVarintLen64]byte
+	_, _ = h.Write(buf[:binary.PutUvarint(buf[:], uint64(n))])
+}
+
+func (h jobHasher) writeString(s string) {
+	h.writeInt(len(s))
+	_, _ = io.WriteString(h, s)
+}
+
+func (h jobHasher) writeStrings(l []string) {
+	h.writeInt(len(l))
+	for _, s := range l {
+		h.writeString(s)
+	}
+}
+
+func (h jobHasher) writeID(id ID) {
+	_, _ = h.Write(id[:])
+}
+
+func (h jobHasher) sum() ID {
+	var id ID
+	copy(id[:], h.Sum(nil))
+	return id
+}
@@ -0,0 +1,126 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newHashGraph(a, b ID) Graph {
+	return Graph{
+		Jobs: []Job{
+			{
+				ID:     b,
+				Name:   "cat",
+				Inputs: []string{"b.txt"},
+				Cmds: []Cmd{
+					{Exec: []string{"cat", fmt.Sprintf("{{index .Deps %q}}/out.txt", a), "{{.SourceDir}}/b.txt"}},
+				},
+				Deps: []ID{a},
+			},
+			{
+				ID:     a,
+				Name:   "write",
+				Inputs: []string{"a.txt"},
+				Cmds: []Cmd{
+					{CatTemplate: "OK", CatOutput: "{{.OutputDir}}/out.txt"},
+				},
+			},
+		},
+	}
+}
+
+func writeSources(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0666))
+	}
+	return dir
+}
+
+func TestSourceFileID(t *testing.T) {
+	dir := writeSources(t, map[string]string{"a.txt": "foo", "b.txt": "foo"})
+
+	a, err := SourceFileID(dir, "a.txt")
+	require.NoError(t, err)
+	b, err := SourceFileID(dir, "b.txt")
+	require.NoError(t, err)
+	require.NotEqual(t, a, b)
+
+	again, err := SourceFileID(dir, "./a.txt")
+	require.NoError(t, err)
+	require.Equal(t, a, again)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("bar"), 0666))
+	changed, err := SourceFileID(dir, "a.txt")
+	require.NoError(t, err)
+	require.NotEqual(t, a, changed)
+}
+
+func TestComputeIDs(t *testing.T) {
+	dir := writeSources(t, map[string]string{"a.txt": "foo", "b.txt": "bar"})
+
+	g1 := newHashGraph(ID{'a'}, ID{'b'})
+	require.NoError(t, g1.ComputeIDs(dir))
+
+	g2 := newHashGraph(ID{'x'}, ID{'y'})
+	require.NoError(t, g2.ComputeIDs(dir))
+
+	require.Equal(t, g1, g2)
+
+	writeID, catID := g1.Jobs[1].ID, g1.Jobs[0].ID
+	require.NotEqual(t, ID{'a'}, writeID)
+	require.Equal(t, []ID{writeID}, g1.Jobs[0].Deps)
+
+	fileID, err := SourceFileID(dir, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "a.txt", g1.SourceFiles[fileID])
+	require.Len(t, g1.SourceFiles, 2)
+
+	cmd, err := g1.Jobs[0].Cmds[0].Render(JobContext{
+		SourceDir: "/src",
+		Deps:      map[ID]string{writeID: "/jobs/a"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cat", "/jobs/a/out.txt", "/src/b.txt"}, cmd.Exec)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed"), 0666))
+
+	g3 := newHashGraph(ID{'a'}, ID{'b'})
+	require.NoError(t, g3.ComputeIDs(dir))
+
+	require.NotEqual(t, writeID, g3.Jobs[1].ID)
+	require.NotEqual(t, catID, g3.Jobs[0].ID)
+}
+
+func TestComputeIDsSameContent(t *testing.T) {
+	// Файлы с одинаковым содержимым по разным путям не должны схлопнуться в SourceFiles.
+	dir := writeSources(t, map[string]string{"a.txt": "foo", "b.txt": "foo"})
+
+	g := newHashGraph(ID{'a'}, ID{'b'})
+	require.NoError(t, g.ComputeIDs(dir))
+
+	var paths []string
+	for _, path := range g.SourceFiles {
+		paths = append(paths, path)
+	}
+	require.ElementsMatch(t, []string{"a.txt", "b.txt"}, paths)
+}
+
+func TestComputeIDsErrors(t *testing.T) {
+	dir := writeSources(t, map[string]string{"a.txt": "foo", "b.txt": "bar"})
+
+	g := newHashGraph(ID{'a'}, ID{'a'})
+	require.Error(t, g.ComputeIDs(dir))
+
+	g = newHashGraph(ID{'a'}, ID{'b'})
+	g.Jobs[0].Deps = append(g.Jobs[0].Deps, ID{'c'})
+	require.Error(t, g.ComputeIDs(dir))
+
+	g = newHashGraph(ID{'a'}, ID{'b'})
+	g.Jobs[0].Inputs = append(g.Jobs[0].Inputs, "missing.txt")
+	require.Error(t, g.ComputeIDs(dir))
+}
@@ -0,0 +1,273 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// ProblemKind задаёт вид ошибки в графе сборки.
+type ProblemKind string
+
+const (
+	// ProblemCycle - граф содержит цикл.
+	ProblemCycle ProblemKind = "cycle"
+	// ProblemUnknownDep - джоб зависит от джоба, которого нет в графе.
+	ProblemUnknownDep ProblemKind = "unknown_dep"
+	// ProblemDuplicateJob - в графе несколько джобов с одинаковым id.
+	ProblemDuplicateJob ProblemKind = "duplicate_job"
+	// ProblemMissingInput - файла из Inputs нет в SourceFiles.
+	ProblemMissingInput ProblemKind = "missing_input"
+	// ProblemUndeclaredDep - шаблон команды ссылается на джоб, которого нет в Deps.
+	ProblemUndeclaredDep ProblemKind = "undeclared_dep"
+	// ProblemBadTemplate - шаблон команды не разбирается.
+	ProblemBadTemplate ProblemKind = "bad_template"
+)
+
+// Problem описывает одну ошибку в графе сборки.
+type Problem struct {
+	Kind ProblemKind
+
+	// Job задаёт джоб, в котором найдена ошибка.
+	Job ID
+
+	// Dep задаёт зависимость, на которую ссылается джоб.
+	Dep *ID `json:",omitempty"`
+
+	// Input задаёт входной файл, которого нет в SourceFiles.
+	Input string `json:",omitempty"`
+
+	// Template задаёт шаблон команды, в котором найдена ошибка.
+	Template string `json:",omitempty"`
+
+	// Cycle задаёт путь по циклу. Первый и последний элементы совпадают.
+	Cycle []ID `json:",omitempty"`
+}
+
+func (p Problem) String() string {
+	switch p.Kind {
+	case ProblemCycle:
+		path := make([]string, 0, len(p.Cycle))
+		for _, id := range p.Cycle {
+			path = append(path, id.String())
+		}
+		return fmt.Sprintf("dependency cycle: %s", strings.Join(path, " -> "))
+	case ProblemUnknownDep:
+		return fmt.Sprintf("job %s depends on unknown job %s", p.Job, p.Dep)
+	case ProblemDuplicateJob:
+		return fmt.Sprintf("duplicate job %s", p.Job)
+	case ProblemMissingInput:
+		return fmt.Sprintf("job %s input %q is missing from source files", p.Job, p.Input)
+	case ProblemUndeclaredDep:
+		return fmt.Sprintf("job %s template %q references undeclared dependency", p.Job, p.Template)
+	case ProblemBadTemplate:
+		return fmt.Sprintf("job %s template %q can not be parsed", p.Job, p.Template)
+	default:
+		return fmt.Sprintf("job %s: %s", p.Job, p.Kind)
+	}
+}
+
+// ValidationError перечисляет все ошибки, найденные в графе сборки.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		problems = append(problems, p.String())
+	}
+	return fmt.Sprintf("invalid build graph: %s", strings.Join(problems, "; "))
+}
+
+// Validate проверяет, что граф можно исполнить.
+//
+// Если граф некорректен, возвращается *ValidationError со всеми найденными ошибками.
+func (g *Graph) Validate() error {
+	var problems []Problem
+
+	jobIndex := make(map[ID]int, len(g.Jobs))
+	for i, job := range g.Jobs {
+		if _, ok := jobIndex[job.ID]; ok {
+			problems = append(problems, Problem{Kind: ProblemDuplicateJob, Job: job.ID})
+			continue
+		}
+		jobIndex[job.ID] = i
+	}
+
+	inputs := make(map[string]struct{}, len(g.SourceFiles))
+	for _, path := range g.SourceFiles {
+		inputs[path] = struct{}{}
+	}
+
+	for _, job := range g.Jobs {
+		deps := make(map[ID]struct{}, len(job.Deps))
+
+		for _, dep := range job.Deps {
+			deps[dep] = struct{}{}
+
+			if _, ok := jobIndex[dep]; !ok {
+				problems = append(problems, Problem{Kind: ProblemUnknownDep, Job: job.ID, Dep: &dep})
+			}
+		}
+
+		for _, input := range job.Inputs {
+			if _, ok := inputs[input]; !ok {
+				problems = append(problems, Problem{Kind: ProblemMissingInput, Job: job.ID, Input: input})
+			}
+		}
+
+		for _, cmd := range job.Cmds {
+			for _, tmpl := range cmdTemplates(&cmd) {
+				problems = append(problems, checkTemplate(job.ID, tmpl, deps)...)
+			}
+		}
+	}
+
+	problems = append(problems, findCycles(g.Jobs, jobIndex)...)
+
+	if len(problems) != 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func cmdTemplates(cmd *Cmd) []string {
+	templates := []string{cmd.CatOutput, cmd.CatTemplate, cmd.WorkingDirectory}
+	templates = append(templates, cmd.Exec...)
+	return append(templates, cmd.Environ...)
+}
+
+func checkTemplate(jobID ID, tmpl string, deps map[ID]struct{}) []Problem {
+	t, err := template.New("").Parse(tmpl)
+	if err != nil {
+		return []Problem{{Kind: ProblemBadTemplate, Job: jobID, Template: tmpl}}
+	}
+
+	var problems []Problem
+	walkDepRefs(t.Root, func(ref string) {
+		problem := Problem{Kind: ProblemUndeclaredDep, Job: jobID, Template: tmpl}
+
+		var dep ID
+		if err := dep.UnmarshalText([]byte(ref)); err == nil {
+			if _, ok := deps[dep]; ok {
+				return
+			}
+			problem.Dep = &dep
+		}
+
+		problems = append(problems, problem)
+	})
+
+	return problems
+}
+
+// walkDepRefs вызывает fn для каждого ключа из выражений вида {{index .Deps "id"}}.
+func walkDepRefs(node parse.Node, fn func(ref string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkDepRefs(child, fn)
+		}
+
+	case *parse.ActionNode:
+		walkDepRefs(n.Pipe, fn)
+
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkDepRefs(cmd, fn)
+		}
+
+	case *parse.CommandNode:
+		if len(n.Args) >= 3 {
+			ident, isIdent := n.Args[0].(*parse.IdentifierNode)
+			field, isField := n.Args[1].(*parse.FieldNode)
+			key, isString := n.Args[2].(*parse.StringNode)
+
+			if isIdent && isField && isString &&
+				ident.Ident == "index" && len(field.Ident) == 1 && field.Ident[0] == "Deps" {
+				fn(key.Text)
+			}
+		}
+		for _, arg := range n.Args {
+			walkDepRefs(arg, fn)
+		}
+
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, fn func(ref string)) {
+	walkDepRefs(n.Pipe, fn)
+	walkDepRefs(n.List, fn)
+	walkDepRefs(n.ElseList, fn)
+}
+
+// findCycles ищет циклы обходом в глубину. Для каждого обратного ребра возвращается отдельный цикл.
+func findCycles(jobs []Job, jobIndex map[ID]int) []Problem {
+	const (
+		white = iota
+		gray
+		black
+	)
+
+	var problems []Problem
+	color := make([]int, len(jobs))
+	var stack []int
+
+	var visit func(i int)
+	visit = func(i int) {
+		color[i] = gray
+		stack = append(stack, i)
+
+		for _, dep := range jobs[i].Deps {
+			j, ok := jobIndex[dep]
+			if !ok {
+				continue
+			}
+
+			switch color[j] {
+			case white:
+				visit(j)
+
+			case gray:
+				start := len(stack) - 1
+				for stack[start] != j {
+					start--
+				}
+
+				cycle := make([]ID, 0, len(stack)-start+1)
+				for _, k := range stack[start:] {
+					cycle = append(cycle, jobs[k].ID)
+				}
+				cycle = append(cycle, jobs[j].ID)
+
+				problems = append(problems, Problem{Kind: ProblemCycle, Job: jobs[j].ID, Cycle: cycle})
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		color[i] = black
+	}
+
+	for i := range jobs {
+		if idx, ok := jobIndex[jobs[i].ID]; ok && idx == i && color[i] == white {
+			visit(i)
+		}
+	}
+
+	return problems
+}
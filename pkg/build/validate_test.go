@@ -0,0 +1,109 @@
+package build
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func validationProblems(t *testing.T, g Graph) []Problem {
+	err := g.Validate()
+	require.Error(t, err)
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	return validationErr.Problems
+}
+
+func TestValidate(t *testing.T) {
+	g := Graph{
+		SourceFiles: map[ID]string{{'f'}: "a.txt"},
+		Jobs: []Job{
+			{
+				ID:     ID{'a'},
+				Inputs: []string{"a.txt"},
+				Cmds: []Cmd{
+					{CatTemplate: "OK", CatOutput: "{{.OutputDir}}/out.txt"},
+				},
+			},
+			{
+				ID:   ID{'b'},
+				Deps: []ID{{'a'}},
+				Cmds: []Cmd{
+					{Exec: []string{"cat", fmt.Sprintf("{{index .Deps %q}}/out.txt", ID{'a'})}},
+				},
+			},
+		},
+	}
+
+	require.NoError(t, g.Validate())
+}
+
+func TestValidateCycle(t *testing.T) {
+	g := Graph{
+		Jobs: []Job{
+			{ID: ID{'a'}, Deps: []ID{{'b'}}},
+			{ID: ID{'b'}, Deps: []ID{{'c'}}},
+			{ID: ID{'c'}, Deps: []ID{{'a'}}},
+			{ID: ID{'d'}, Deps: []ID{{'a'}}},
+		},
+	}
+
+	problems := validationProblems(t, g)
+	require.Len(t, problems, 1)
+	require.Equal(t, ProblemCycle, problems[0].Kind)
+	require.Equal(t, []ID{{'a'}, {'b'}, {'c'}, {'a'}}, problems[0].Cycle)
+}
+
+func TestValidateProblems(t *testing.T) {
+	g := Graph{
+		SourceFiles: map[ID]string{{'f'}: "a.txt"},
+		Jobs: []Job{
+			{ID: ID{'a'}, Inputs: []string{"a.txt", "b.txt"}},
+			{ID: ID{'a'}},
+			{
+				ID:   ID{'b'},
+				Deps: []ID{{'x'}},
+				Cmds: []Cmd{
+					{Exec: []string{"cat", fmt.Sprintf("{{index .Deps %q}}/out.txt", ID{'a'})}},
+					{CatOutput: "{{.OutputDir"},
+				},
+			},
+		},
+	}
+
+	problems := validationProblems(t, g)
+
+	kinds := map[ProblemKind]Problem{}
+	for _, p := range problems {
+		kinds[p.Kind] = p
+	}
+
+	require.Len(t, kinds, 5, "%v", problems)
+	require.Equal(t, ID{'a'}, kinds[ProblemDuplicateJob].Job)
+	require.Equal(t, "b.txt", kinds[ProblemMissingInput].Input)
+	require.Equal(t, &ID{'x'}, kinds[ProblemUnknownDep].Dep)
+	require.Equal(t, &ID{'a'}, kinds[ProblemUndeclaredDep].Dep)
+	require.Equal(t, ID{'b'}, kinds[ProblemBadTemplate].Job)
+}
+
+func TestValidationErrorJSON(t *testing.T) {
+	g := Graph{
+		Jobs: []Job{
+			{ID: ID{'a'}, Deps: []ID{{'a'}}},
+		},
+	}
+
+	err := g.Validate()
+	require.Error(t, err)
+
+	data, jsonErr := json.Marshal(err)
+	require.NoError(t, jsonErr)
+
+	var decoded ValidationError
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, err, &decoded)
+}
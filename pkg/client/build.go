@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	_ "net/http/pprof"
 
@@ -37,21 +38,55 @@ func NewClient(
 	}
 }
 
+// cancelTimeout ограничивает время, которое клиент тратит на отмену билда.
+const cancelTimeout = 5 * time.Second
+
 type BuildListener interface {
 	OnJobStdout(jobID build.ID, stdout []byte) error
 	OnJobStderr(jobID build.ID, stderr []byte) error
 
 	OnJobFinished(jobID build.ID) error
 	OnJobFailed(jobID build.ID, code int, err string) error
+
+	// OnJobSkipped вызывается для джобов, которые не запускались из-за упавшей зависимости failedDep.
+	OnJobSkipped(jobID build.ID, failedDep build.ID) error
+
+	// OnJobResources вызывается перед OnJobFinished или OnJobFailed для джобов, которые
+	// исполнялись на воркере, и сообщает потраченные ими ресурсы.
+	OnJobResources(jobID build.ID, usage api.ResourceUsage) error
+}
+
+// BuildOptions задаёт параметры билда.
+type BuildOptions struct {
+	// Mode задаёт поведение билда при падении джоба. По умолчанию api.BuildModeFailFast.
+	Mode api.BuildMode
+
+	// Timeout ограничивает время исполнения билда на координаторе. По умолчанию действует
+	// ограничение координатора.
+	Timeout time.Duration
 }
 
 func (c *Client) Build(ctx context.Context, graph build.Graph, lsn BuildListener) error {
-	c.l.Info("build new started")
+	return c.BuildWithOptions(ctx, graph, BuildOptions{}, lsn)
+}
+
+func (c *Client) BuildWithOptions(ctx context.Context, graph build.Graph, opts BuildOptions, lsn BuildListener) error {
+	c.l.Info("build new started", zap.String("mode", string(opts.Mode)))
+
+	graph, err := c.contentAddressed(graph)
+	if err != nil {
+		c.l.Error("failed to hash source files", zap.Error(err))
+		return err
+	}
 
 	buildClient := api.NewBuildClient(c.l, c.apiEndpoint)
 	fileCacheClient := filecache.NewClient(c.l, c.apiEndpoint)
 
-	started, statusReader, err := buildClient.StartBuild(ctx, &api.BuildRequest{Graph: graph})
+	started, statusReader, err := buildClient.StartBuild(ctx, &api.BuildRequest{
+		Graph:   graph,
+		Mode:    opts.Mode,
+		Timeout: opts.Timeout,
+	})
 	if err != nil {
 		c.l.Error("failed to start build", zap.Error(err))
 		return fmt.Errorf("start build: %w", err)
@@ -63,6 +98,20 @@ func (c *Client) Build(ctx context.Context, graph build.Graph, lsn BuildListener
 	logger.Info("build started",
 		zap.Int("missing_files", len(started.MissingFiles)))
 
+	defer func() {
+		if ctx.Err() == nil {
+			return
+		}
+
+		// ctx уже отменён, поэтому для отмены билда нужен свой контекст.
+		cancelCtx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+		defer cancel()
+
+		if err := buildClient.CancelBuild(cancelCtx, started.ID, ctx.Err().Error()); err != nil {
+			logger.Warn("failed to cancel build", zap.Error(err))
+		}
+	}()
+
 	errs := make(chan error, len(started.MissingFiles))
 	for _, fileID := range started.MissingFiles {
 		filePath, ok := graph.SourceFiles[fileID]
@@ -92,7 +141,7 @@ func (c *Client) Build(ctx context.Context, graph build.Graph, lsn BuildListener
 		}
 
 		go func() {
-			if err := fileCacheClient.Upload(ctx, fileID, fullPath); err != nil {
+			if err := fileCacheClient.Upload(ctx, fileID, filePath, fullPath); err != nil {
 				logger.Error("failed to upload file",
 					zap.String("file_id", fileID.String()),
 					zap.String("path", fullPath),
@@ -133,6 +182,26 @@ func (c *Client) Build(ctx context.Context, graph build.Graph, lsn BuildListener
 	}
 }
 
+// contentAddressed возвращает копию графа, в которой id исходных файлов вычислены по их пути
+// и содержимому (см. build.SourceFileID).
+//
+// Координатор просит залить только те файлы, которых ещё нет в его кеше, поэтому id файла
+// должен однозначно задавать его содержимое.
+func (c *Client) contentAddressed(graph build.Graph) (build.Graph, error) {
+	sourceFiles := make(map[build.ID]string, len(graph.SourceFiles))
+	for _, path := range graph.SourceFiles {
+		fileID, err := build.SourceFileID(c.sourceDir, path)
+		if err != nil {
+			return graph, fmt.Errorf("hash file %s: %w", path, err)
+		}
+
+		sourceFiles[fileID] = path
+	}
+
+	graph.SourceFiles = sourceFiles
+	return graph, nil
+}
+
 func listenBuild(ctx context.Context, statusReader api.StatusReader, lsn BuildListener, logger *zap.Logger) error {
 
 	var update *api.StatusUpdate
@@ -161,12 +230,21 @@ func listenBuild(ctx context.Context, statusReader api.StatusReader, lsn BuildLi
 		return fmt.Errorf("status update: %w", err)
 	}
 
-	switch {
-
-	case update.JobFinished != nil:
+	// Одно обновление может содержать несколько полей, например BuildFailed вместе с BuildFinished.
+	if update.JobFinished != nil {
 		logger.Info("job finished",
 			zap.String("job_id", update.JobFinished.ID.String()),
-			zap.Int("exit_code", update.JobFinished.ExitCode))
+			zap.Int("exit_code", update.JobFinished.ExitCode),
+			zap.Bool("timed_out", update.JobFinished.TimedOut))
+
+		if update.JobFinished.Resources != nil {
+			if err := lsn.OnJobResources(update.JobFinished.ID, *update.JobFinished.Resources); err != nil {
+				logger.Error("err in BuildListener.OnJobResources",
+					zap.Error(err),
+					zap.Any("update", update))
+				return fmt.Errorf("err in BuildListener.OnJobResources: %w", err)
+			}
+		}
 
 		if update.JobFinished.Error != nil {
 			if err := lsn.OnJobFailed(update.JobFinished.ID, update.JobFinished.ExitCode, *update.JobFinished.Error); err != nil {
@@ -197,15 +275,28 @@ func listenBuild(ctx context.Context, statusReader api.StatusReader, lsn BuildLi
 				zap.ByteString("stdout", update.JobFinished.Stdout))
 			return fmt.Errorf("err in BuildListener.OnJobStdout: %w", err)
 		}
+	}
+
+	if update.JobSkipped != nil {
+		logger.Info("job skipped",
+			zap.String("job_id", update.JobSkipped.ID.String()),
+			zap.String("failed_dep", update.JobSkipped.FailedDep.String()))
 
-	case update.BuildFailed != nil:
+		if err := lsn.OnJobSkipped(update.JobSkipped.ID, update.JobSkipped.FailedDep); err != nil {
+			logger.Error("err in BuildListener.OnJobSkipped",
+				zap.Error(err),
+				zap.Any("update", update))
+			return fmt.Errorf("err in BuildListener.OnJobSkipped: %w", err)
+		}
+	}
+
+	if update.BuildFailed != nil {
 		logger.Error("build failed", zap.String("error", update.BuildFailed.Error))
 		return fmt.Errorf("build failed: %s", update.BuildFailed.Error)
+	}
 
-	case update.BuildFinished != nil:
+	if update.BuildFinished != nil {
 		logger.Info("build finished successfully")
-		return nil
-
 	}
 
 	return nil
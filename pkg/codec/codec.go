@@ -0,0 +1,147 @@
+// Package codec сжимает файлы и артефакты, которые передаются по HTTP.
+package codec
+
+import (
+	"compress/gzip"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Codec сжимает и разжимает поток. Name совпадает со значением заголовков Accept-Encoding
+// и Content-Encoding.
+type Codec interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Identity - имя кодирования без сжатия.
+const Identity = "identity"
+
+var (
+	mu     sync.RWMutex
+	codecs []Codec
+)
+
+func init() {
+	Register(Gzip(gzip.BestSpeed))
+}
+
+// Register добавляет кодек. Кодеки, зарегистрированные раньше, предпочтительнее;
+// кодек с тем же именем заменяется.
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if i := slices.IndexFunc(codecs, func(other Codec) bool { return other.Name() == c.Name() }); i >= 0 {
+		codecs[i] = c
+		return
+	}
+	codecs = append(codecs, c)
+}
+
+// Lookup возвращает кодек по имени.
+func Lookup(name string) (Codec, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, c := range codecs {
+		if strings.EqualFold(c.Name(), name) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Preferred возвращает кодек, которым клиент сжимает загружаемые данные, или nil, если кодеков нет.
+func Preferred() Codec {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if len(codecs) == 0 {
+		return nil
+	}
+	return codecs[0]
+}
+
+// AcceptEncoding возвращает значение заголовка Accept-Encoding со всеми зарегистрированными кодеками.
+func AcceptEncoding() string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(codecs)+1)
+	for _, c := range codecs {
+		names = append(names, c.Name())
+	}
+	names = append(names, Identity)
+	return strings.Join(names, ", ")
+}
+
+// Negotiate выбирает кодек для ответа по заголовку Accept-Encoding запроса. Если клиент не принимает
+// ни один из зарегистрированных кодеков, возвращает nil: ответ передаётся без сжатия.
+//
+// Веса q учитываются только для отказа от кодека (q=0); среди принятых выбирается кодек,
+// зарегистрированный раньше.
+func Negotiate(acceptEncoding string) Codec {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		accepted[strings.ToLower(name)] = weight(params) > 0
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, c := range codecs {
+		if accepted[strings.ToLower(c.Name())] {
+			return c
+		}
+	}
+	return nil
+}
+
+// weight возвращает вес q из параметров кодирования, по умолчанию 1.
+func weight(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if key != "q" {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
+type gzipCodec struct {
+	level int
+}
+
+// Gzip возвращает кодек gzip с заданным уровнем сжатия (см. compress/gzip).
+func Gzip(level int) Codec {
+	return gzipCodec{level: level}
+}
+
+func (gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (c gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
@@ -0,0 +1,105 @@
+package codec_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/justnurik/distbuild/pkg/codec"
+)
+
+func TestNegotiate(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                      "",
+		"identity":              "",
+		"gzip":                  "gzip",
+		"br, GZIP;q=0.5":        "gzip",
+		"gzip;q=0, identity":    "",
+		"deflate, gzip ; q=0.1": "gzip",
+	} {
+		c := codec.Negotiate(header)
+		if want == "" {
+			require.Nil(t, c, header)
+		} else {
+			require.NotNil(t, c, header)
+			require.Equal(t, want, c.Name(), header)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	content := bytes.Repeat([]byte("object file "), 4096)
+
+	for _, c := range []codec.Codec{nil, codec.Gzip(gzip.BestSpeed)} {
+		var wire bytes.Buffer
+
+		w, err := codec.NewWriter(&wire, c)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		encoding := codec.Identity
+		if c != nil {
+			encoding = c.Name()
+		}
+
+		r, err := codec.NewReader(&wire, encoding)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, content, got)
+
+		require.Equal(t, w.Stats(), r.Stats())
+		require.Equal(t, int64(len(content)), w.Stats().Raw)
+		if c != nil {
+			require.Less(t, w.Stats().Ratio(), 0.1)
+		} else {
+			require.Equal(t, 1.0, w.Stats().Ratio())
+		}
+	}
+
+	_, err := codec.NewReader(bytes.NewReader(nil), "zstd")
+	require.ErrorIs(t, err, codec.ErrUnsupported)
+}
+
+func TestEncodeResponse(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 1024)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := codec.EncodeResponse(w, r)
+		require.NoError(t, err)
+		_, _ = body.Write(content)
+		require.NoError(t, body.Close())
+	}))
+	defer server.Close()
+
+	for _, acceptEncoding := range []string{codec.AcceptEncoding(), "identity"} {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		body, err := codec.DecodeResponse(resp)
+		require.NoError(t, err)
+		got, err := io.ReadAll(body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, content, got, acceptEncoding)
+		if acceptEncoding == "identity" {
+			require.Empty(t, resp.Header.Get("Content-Encoding"))
+		} else {
+			require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+			require.Less(t, body.Stats().Wire, body.Stats().Raw)
+		}
+	}
+}
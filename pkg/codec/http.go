@@ -0,0 +1,179 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// ErrUnsupported возвращается, если данные сжаты неизвестным кодеком.
+var ErrUnsupported = errors.New("unsupported content encoding")
+
+// Stats описывает одну передачу: сколько байт было до сжатия и сколько ушло по сети.
+type Stats struct {
+	Encoding string
+	Raw      int64
+	Wire     int64
+}
+
+// Ratio возвращает отношение переданных байт к исходным.
+func (s Stats) Ratio() float64 {
+	if s.Raw == 0 {
+		return 1
+	}
+	return float64(s.Wire) / float64(s.Raw)
+}
+
+// Log пишет в лог степень сжатия передачи.
+func (s Stats) Log(l *zap.Logger, msg string, fields ...zap.Field) {
+	l.Info(msg, append(fields,
+		zap.String("encoding", s.Encoding),
+		zap.Int64("raw_bytes", s.Raw),
+		zap.Int64("wire_bytes", s.Wire),
+		zap.Float64("compression_ratio", s.Ratio()))...)
+}
+
+// Writer сжимает данные кодеком и считает байты до и после сжатия.
+type Writer struct {
+	raw  counter
+	wire counter
+
+	w        io.WriteCloser
+	encoding string
+}
+
+// NewWriter сжимает данные, записанные в Writer, кодеком c и пишет их в w.
+// Если c равен nil, данные передаются как есть. Close не закрывает w.
+func NewWriter(w io.Writer, c Codec) (*Writer, error) {
+	cw := &Writer{encoding: Identity}
+	cw.wire.w = w
+
+	if c == nil {
+		cw.w = nopCloser{&cw.wire}
+	} else {
+		enc, err := c.NewWriter(&cw.wire)
+		if err != nil {
+			return nil, err
+		}
+		cw.w = enc
+		cw.encoding = c.Name()
+	}
+
+	cw.raw.w = cw.w
+	return cw, nil
+}
+
+// EncodeResponse выбирает кодек по заголовку Accept-Encoding запроса, выставляет Content-Encoding
+// и возвращает Writer для тела ответа. Writer нужно закрыть до возврата из хендлера.
+func EncodeResponse(w http.ResponseWriter, r *http.Request) (*Writer, error) {
+	c := Negotiate(r.Header.Get("Accept-Encoding"))
+	if c != nil {
+		w.Header().Set("Content-Encoding", c.Name())
+		w.Header().Del("Content-Length")
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	return NewWriter(w, c)
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.raw.Write(p)
+}
+
+// Close дописывает сжатый поток.
+func (w *Writer) Close() error {
+	return w.w.Close()
+}
+
+// Stats возвращает статистику передачи. Сжатый размер окончателен только после Close.
+func (w *Writer) Stats() Stats {
+	return Stats{Encoding: w.encoding, Raw: w.raw.n, Wire: w.wire.n}
+}
+
+// Reader разжимает данные и считает байты до и после разжатия.
+type Reader struct {
+	raw  counter
+	wire counter
+
+	r        io.ReadCloser
+	encoding string
+}
+
+// NewReader разжимает поток r, сжатый кодеком с именем encoding. Пустое имя и Identity
+// означают поток без сжатия. Для неизвестного кодека возвращается ErrUnsupported.
+func NewReader(r io.Reader, encoding string) (*Reader, error) {
+	cr := &Reader{encoding: Identity}
+	cr.wire.r = r
+
+	if encoding == "" || encoding == Identity {
+		cr.r = io.NopCloser(&cr.wire)
+	} else {
+		c, ok := Lookup(encoding)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnsupported, encoding)
+		}
+
+		dec, err := c.NewReader(&cr.wire)
+		if err != nil {
+			return nil, err
+		}
+		cr.r = dec
+		cr.encoding = c.Name()
+	}
+
+	cr.raw.r = cr.r
+	return cr, nil
+}
+
+// DecodeResponse разжимает тело ответа по заголовку Content-Encoding.
+func DecodeResponse(resp *http.Response) (*Reader, error) {
+	return NewReader(resp.Body, resp.Header.Get("Content-Encoding"))
+}
+
+// DecodeRequest разжимает тело запроса по заголовку Content-Encoding.
+func DecodeRequest(r *http.Request) (*Reader, error) {
+	return NewReader(r.Body, r.Header.Get("Content-Encoding"))
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.raw.Read(p)
+}
+
+// Close освобождает кодек, но не закрывает исходный поток.
+func (r *Reader) Close() error {
+	return r.r.Close()
+}
+
+// Stats возвращает статистику прочитанной части потока.
+func (r *Reader) Stats() Stats {
+	return Stats{Encoding: r.encoding, Raw: r.raw.n, Wire: r.wire.n}
+}
+
+type counter struct {
+	r io.Reader
+	w io.Writer
+	n int64
+}
+
+func (c *counter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -36,3 +36,24 @@ func (s *SyncMap[K, V]) LoadUnsafe(key K) V {
 
 	return s.data[key]
 }
+
+func (s *SyncMap[K, V]) Delete(key K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data, key)
+}
+
+// Range вызывает f для каждой пары ключ-значение, пока f возвращает true.
+//
+// f вызывается под блокировкой на чтение, поэтому не должна менять эту же мапу.
+func (s *SyncMap[K, V]) Range(f func(key K, val V) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for key, val := range s.data {
+		if !f(key, val) {
+			return
+		}
+	}
+}
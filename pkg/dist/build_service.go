@@ -2,17 +2,42 @@ package dist
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
-	"sync/atomic"
 
 	"gitlab.com/justnurik/distbuild/pkg/api"
 	"gitlab.com/justnurik/distbuild/pkg/build"
-	"gitlab.com/justnurik/distbuild/pkg/filecache"
-	"gitlab.com/justnurik/distbuild/pkg/scheduler"
 	"go.uber.org/zap"
 )
 
+var (
+	// ErrBuildCancelled возвращается, если билд отменили сигналом Cancel.
+	ErrBuildCancelled = errors.New("build cancelled")
+
+	// ErrBuildTimedOut возвращается, если билд не уложился в отведённое время.
+	ErrBuildTimedOut = errors.New("build timed out")
+)
+
+// runningBuild хранит состояние билда между StartBuild и SignalBuild.
+type runningBuild struct {
+	cancel context.CancelCauseFunc
+
+	uploadDone     chan struct{}
+	uploadDoneOnce sync.Once
+}
+
+func newRunningBuild(cancel context.CancelCauseFunc) *runningBuild {
+	return &runningBuild{
+		cancel:     cancel,
+		uploadDone: make(chan struct{}),
+	}
+}
+
+func (b *runningBuild) signalUploadDone() {
+	b.uploadDoneOnce.Do(func() { close(b.uploadDone) })
+}
+
 type buildService struct {
 	l *zap.Logger
 	*coordinatorCore
@@ -26,13 +51,30 @@ func NewBuildService(l *zap.Logger, core *coordinatorCore) *buildService {
 }
 
 func (c *buildService) StartBuild(ctx context.Context, request *api.BuildRequest, w api.StatusWriter) error {
+	if err := request.Graph.Validate(); err != nil {
+		c.l.Error("invalid build graph", zap.Error(err))
+		return err
+	}
+
+	fileIDs := make([]build.ID, 0, len(request.Graph.SourceFiles))
+	for fileID := range request.Graph.SourceFiles {
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	// Файлы билда закрепляются до проверки кеша, чтобы сборщик мусора не удалил их,
+	// пока билд не завершится.
+	release := c.fileCache.Pin(fileIDs)
+	defer release()
+
 	missingFiles := make([]build.ID, 0)
 	fileNameToID := make(map[string]build.ID)
 
-	// todo: randevu [client -----<download/upload files>-----> worker]
+	// Id файлов задают их содержимое, поэтому файл, который уже есть в кеше, заливать заново не нужно.
 	for fileID, filePath := range request.Graph.SourceFiles {
 		fileNameToID[filePath] = fileID
-		missingFiles = append(missingFiles, fileID)
+		if !c.fileCache.Has(fileID) {
+			missingFiles = append(missingFiles, fileID)
+		}
 	}
 
 	started := &api.BuildStarted{
@@ -40,6 +82,27 @@ func (c *buildService) StartBuild(ctx context.Context, request *api.BuildRequest
 		MissingFiles: missingFiles,
 	}
 
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	timeout := request.Timeout
+	if timeout == 0 {
+		timeout = c.buildTimeout
+	}
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %s", ErrBuildTimedOut, timeout))
+		defer cancelTimeout()
+	}
+
+	// Билд регистрируется до первого сообщения, чтобы клиент не мог прислать сигнал раньше.
+	b := newRunningBuild(cancel)
+	c.builds.Store(started.ID, b)
+	defer c.builds.Delete(started.ID)
+
+	c.running.Add(1)
+	defer c.running.Done()
+
 	if err := w.Started(started); err != nil {
 		c.l.Error("error sending the first message by the coordinator",
 			zap.Error(err),
@@ -60,119 +123,44 @@ func (c *buildService) StartBuild(ctx context.Context, request *api.BuildRequest
 		}
 	}
 
-	c.hb.Happen(started.ID, func() {
-		c.buildSourceFiles.Store(started.ID, sourceFiles)
-		c.buildGraph.Store(started.ID, jobs)
-		c.buildStatusWriter.Store(started.ID, w)
-	})
-
-	return nil
-}
-
-func (c *buildService) SignalBuild(ctx context.Context, buildID build.ID, signal *api.SignalRequest) (*api.SignalResponse, error) {
-	c.hb.Before(buildID)
+	logger := c.l.With(zap.String("build_id", started.ID.String()))
 
-	if signal.UploadDone == nil {
-		return &api.SignalResponse{}, nil
+	select {
+	case <-b.uploadDone:
+	case <-ctx.Done():
+		logger.Info("build cancelled before upload finished", zap.Error(context.Cause(ctx)))
+		return context.Cause(ctx)
 	}
 
-	sourceFiles, exist1 := c.buildSourceFiles.Load(buildID)
-	jobs, exist2 := c.buildGraph.Load(buildID)
-	sw, exist3 := c.buildStatusWriter.Load(buildID)
-
-	if !exist3 || !exist2 || !exist1 {
-		panic("concurrency.HappenceBeforeMachine does not work: require call the function `StartBuild` before `SignalBuild`")
-	}
-
-	jobPending := make(map[build.ID]*scheduler.PendingJob)
-	finishedJobCount := atomic.Uint64{}
-
-	var wg sync.WaitGroup
-	errs := make([]error, 0, len(jobs))
-
-	wg.Add(len(jobs))
-
-	for i, job := range jobs {
-		artifacts := make(map[build.ID]api.WorkerID)
-
-		for _, dep := range job.Deps {
-			depPending, exist := jobPending[dep]
-			if !exist {
-				panic("top sort does not work")
-			}
-
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			case <-depPending.Finished: // wait worker
-			}
-
-			depWorkerID, exist := c.sched.LocateArtifact(dep)
-			if !exist {
-				panic("top sort does not work")
-			}
-
-			artifacts[dep] = depWorkerID
+	executor := newBuildExecutor(logger, c.coordinatorCore, jobs, sourceFiles, w, request.Mode)
+	if err := executor.run(ctx); err != nil {
+		if ctx.Err() != nil {
+			logger.Info("build cancelled", zap.Error(context.Cause(ctx)))
+			return context.Cause(ctx)
 		}
-
-		pending := c.sched.ScheduleJob(&api.JobSpec{
-			SourceFiles: sourceFiles[i],
-			Artifacts:   artifacts,
-			Job:         job})
-		jobPending[job.ID] = pending
-
-		go func() {
-			defer wg.Done()
-
-			select {
-			case <-ctx.Done():
-				errs = append(errs, ctx.Err())
-				return
-			case <-pending.Finished:
-				finishedJobCount.Add(1)
-			}
-
-			update := api.StatusUpdate{JobFinished: pending.Result}
-			if pending.Result.Error != nil {
-				update.BuildFailed = &api.BuildFailed{
-					Error: *pending.Result.Error,
-				}
-			}
-			if finishedJobCount.CompareAndSwap(uint64(len(jobs)), 0) {
-				update.BuildFinished = &api.BuildFinished{}
-			}
-			if err := sw.Updated(&update); err != nil {
-				c.l.Error("error when trying to update the build status",
-					zap.Error(err),
-					zap.Any("update", update))
-				errs = append(errs, fmt.Errorf("error when trying to update the build status: %w", err))
-				return
-			}
-
-			if err := removeSourceFiles(pending.Job.SourceFiles, c.fileCache, c.l); err != nil {
-				c.l.Warn("couldn't delete the sources on the coordinator", zap.Error(err))
-			}
-		}()
+		return err
 	}
 
-	wg.Wait()
+	return nil
+}
 
-	for _, err := range errs {
-		return nil, err
+func (c *buildService) SignalBuild(ctx context.Context, buildID build.ID, signal *api.SignalRequest) (*api.SignalResponse, error) {
+	b, exist := c.builds.Load(buildID)
+	if !exist {
+		c.l.Error("signal to unknown build", zap.String("build_id", buildID.String()))
+		return nil, fmt.Errorf("build %s not found", buildID)
 	}
 
-	return &api.SignalResponse{}, ctx.Err()
-}
+	if signal.UploadDone != nil {
+		b.signalUploadDone()
+	}
 
-func removeSourceFiles(sourceFiles map[build.ID]string, fileCache *filecache.Cache, logger *zap.Logger) error {
-	for fileID := range sourceFiles {
-		if err := fileCache.Remove(fileID); err != nil {
-			logger.Warn("couldn't delete the file",
-				zap.Error(err),
-				zap.String("file", sourceFiles[fileID]))
-			return fmt.Errorf("couldn't delete the file: %w", err)
-		}
+	if signal.Cancel != nil {
+		c.l.Info("cancel build",
+			zap.String("build_id", buildID.String()),
+			zap.String("reason", signal.Cancel.Reason))
+		b.cancel(fmt.Errorf("%w: %s", ErrBuildCancelled, signal.Cancel.Reason))
 	}
 
-	return nil
+	return &api.SignalResponse{}, nil
 }
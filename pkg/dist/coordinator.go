@@ -3,7 +3,10 @@
 package dist
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -20,44 +23,78 @@ type coordinatorCore struct {
 	fileCache *filecache.Cache
 
 	// buildID ->
-	buildGraph        *concurrency.SyncMap[build.ID, []build.Job]
-	buildSourceFiles  *concurrency.SyncMap[build.ID, []map[build.ID]string]
-	buildStatusWriter *concurrency.SyncMap[build.ID, api.StatusWriter]
+	builds *concurrency.SyncMap[build.ID, *runningBuild]
 
-	hb *concurrency.HappenceBeforeMachine[build.ID]
+	// running считает билды, для которых ещё не вернулся StartBuild.
+	running sync.WaitGroup
+
+	buildTimeout time.Duration
 }
 
 type Coordinator struct {
 	log  *zap.Logger
 	mux  *http.ServeMux
 	core *coordinatorCore
+
+	stopGC chan struct{}
+	gcDone chan struct{}
 }
 
-var defaultConfig = scheduler.Config{
-	CacheTimeout: time.Millisecond * 10,
-	DepsTimeout:  time.Millisecond * 100,
+// Config задаёт параметры координатора.
+type Config struct {
+	Scheduler scheduler.Config
+
+	// SourceFileTTL - сколько исходный файл, не нужный ни одному билду, хранится в кеше.
+	// Файлы остаются в кеше между билдами, чтобы не заливать их заново.
+	// Нулевое значение отключает сборку мусора.
+	SourceFileTTL time.Duration
+
+	// BuildTimeout ограничивает время исполнения билда, если клиент не задал BuildRequest.Timeout.
+	// Нулевое значение означает, что ограничения нет.
+	BuildTimeout time.Duration
+}
+
+// DefaultConfig возвращает параметры координатора по умолчанию.
+func DefaultConfig() Config {
+	return Config{
+		Scheduler: scheduler.Config{
+			CacheTimeout:  time.Millisecond * 10,
+			DepsTimeout:   time.Millisecond * 100,
+			WorkerTimeout: time.Second * 5,
+		},
+		SourceFileTTL: time.Hour,
+	}
 }
 
 func NewCoordinator(
 	log *zap.Logger,
 	fileCache *filecache.Cache,
 ) *Coordinator {
+	return NewCoordinatorWithConfig(log, fileCache, DefaultConfig())
+}
+
+func NewCoordinatorWithConfig(
+	log *zap.Logger,
+	fileCache *filecache.Cache,
+	config Config,
+) *Coordinator {
 
 	core := &coordinatorCore{
-		sched:     scheduler.NewScheduler(log, defaultConfig, time.After),
+		sched:     scheduler.NewScheduler(log, config.Scheduler, time.After),
 		fileCache: fileCache,
 
-		buildGraph:        concurrency.NewSyncMap[build.ID, []build.Job](0),
-		buildSourceFiles:  concurrency.NewSyncMap[build.ID, []map[build.ID]string](0),
-		buildStatusWriter: concurrency.NewSyncMap[build.ID, api.StatusWriter](0),
+		buildTimeout: config.BuildTimeout,
 
-		hb: concurrency.NewHappenceBeforeMachine[build.ID](),
+		builds: concurrency.NewSyncMap[build.ID, *runningBuild](0),
 	}
 
 	c := &Coordinator{
 		log:  log,
 		mux:  http.NewServeMux(),
 		core: core,
+
+		stopGC: make(chan struct{}),
+		gcDone: make(chan struct{}),
 	}
 
 	buildHandler := api.NewBuildService(log, NewBuildService(log, core))
@@ -67,11 +104,66 @@ func NewCoordinator(
 	buildHandler.Register(c.mux)
 	heartbeatHandler.Register(c.mux)
 	fileCacheHandler.Register(c.mux)
+	c.mux.HandleFunc("/workers", c.handleWorkers)
+
+	go c.collectSourceFiles(config.SourceFileTTL)
 
 	return c
 }
 
+// collectSourceFiles периодически удаляет из кеша исходные файлы, которые дольше ttl
+// не нужны ни одному билду.
+func (c *Coordinator) collectSourceFiles(ttl time.Duration) {
+	defer close(c.gcDone)
+
+	if ttl <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopGC:
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := c.core.fileCache.Collect(ttl)
+		if err != nil {
+			c.log.Warn("failed to collect source files", zap.Error(err))
+		}
+		if len(removed) != 0 {
+			c.log.Info("source files removed", zap.Int("count", len(removed)))
+		}
+	}
+}
+
+// handleWorkers отдаёт состояние воркеров в формате JSON.
+func (c *Coordinator) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		c.log.Error("unsupported method", zap.String("method", r.Method))
+		http.Error(w, "GET request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.core.sched.WorkerStates()); err != nil {
+		c.log.Error("failed to write worker states", zap.Error(err))
+	}
+}
+
 func (c *Coordinator) Stop() {
+	c.core.builds.Range(func(_ build.ID, b *runningBuild) bool {
+		b.cancel(fmt.Errorf("%w: coordinator is stopping", ErrBuildCancelled))
+		return true
+	})
+	c.core.running.Wait()
+
+	close(c.stopGC)
+	<-c.gcDone
+
 	c.core.sched.Stop()
 }
 
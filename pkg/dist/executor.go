@@ -0,0 +1,247 @@
+package dist
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"gitlab.com/justnurik/distbuild/pkg/api"
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// buildExecutor исполняет граф одного билда.
+//
+// Джоб отправляется в шедулер сразу, как только завершились все его зависимости,
+// поэтому независимые цепочки графа исполняются параллельно.
+//
+// В режиме BuildModeFailFast первый упавший джоб останавливает билд. В режиме BuildModeKeepGoing
+// джобы, зависящие от упавших, пропускаются, а остальные доводятся до конца.
+type buildExecutor struct {
+	l *zap.Logger
+	*coordinatorCore
+
+	sw   api.StatusWriter
+	mode api.BuildMode
+
+	jobs        map[build.ID]*api.JobSpec
+	pendingDeps map[build.ID]int
+	dependents  map[build.ID][]build.ID
+
+	// scheduled хранит джобы, которые отправлены в шедулер и ещё не завершились.
+	scheduled map[build.ID]struct{}
+
+	// skipped хранит джобы, пропущенные из-за упавших зависимостей.
+	skipped map[build.ID]struct{}
+
+	finished chan finishedJob
+	wg       sync.WaitGroup
+}
+
+type finishedJob struct {
+	id     build.ID
+	result *api.JobResult
+}
+
+func newBuildExecutor(
+	l *zap.Logger,
+	core *coordinatorCore,
+	jobs []build.Job,
+	sourceFiles []map[build.ID]string,
+	sw api.StatusWriter,
+	mode api.BuildMode,
+) *buildExecutor {
+	e := &buildExecutor{
+		l:               l,
+		coordinatorCore: core,
+		sw:              sw,
+		mode:            mode,
+
+		jobs:        make(map[build.ID]*api.JobSpec, len(jobs)),
+		pendingDeps: make(map[build.ID]int, len(jobs)),
+		dependents:  make(map[build.ID][]build.ID, len(jobs)),
+		scheduled:   make(map[build.ID]struct{}, len(jobs)),
+		skipped:     make(map[build.ID]struct{}),
+
+		finished: make(chan finishedJob),
+	}
+
+	for i, job := range jobs {
+		e.jobs[job.ID] = &api.JobSpec{
+			SourceFiles: sourceFiles[i],
+			Job:         job,
+		}
+		e.pendingDeps[job.ID] = len(job.Deps)
+
+		for _, dep := range job.Deps {
+			e.dependents[dep] = append(e.dependents[dep], job.ID)
+		}
+	}
+
+	return e
+}
+
+// run исполняет граф и возвращается, когда все джобы завершились или отменён ctx.
+//
+// Незавершённые к этому моменту джобы отменяются в шедулере.
+func (e *buildExecutor) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		e.wg.Wait()
+
+		for jobID := range e.scheduled {
+			e.sched.CancelJob(jobID)
+		}
+	}()
+
+	for jobID, deps := range e.pendingDeps {
+		if deps == 0 {
+			if err := e.schedule(ctx, jobID); err != nil {
+				return err
+			}
+		}
+	}
+
+	failed := 0
+
+	for finishedCount := 0; finishedCount < len(e.jobs); {
+		var finished finishedJob
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case finished = <-e.finished:
+			finishedCount++
+			delete(e.scheduled, finished.id)
+		}
+
+		if err := e.update(&api.StatusUpdate{JobFinished: finished.result}); err != nil {
+			return err
+		}
+
+		if finished.result.Error != nil {
+			if e.mode != api.BuildModeKeepGoing {
+				return fmt.Errorf("job %s failed: %s", finished.id, *finished.result.Error)
+			}
+
+			failed++
+
+			skipped, err := e.skipDependents(finished.id)
+			if err != nil {
+				return err
+			}
+			finishedCount += skipped
+
+			continue
+		}
+
+		for _, dependent := range e.dependents[finished.id] {
+			e.pendingDeps[dependent]--
+			if e.pendingDeps[dependent] != 0 {
+				continue
+			}
+
+			if err := e.schedule(ctx, dependent); err != nil {
+				return err
+			}
+		}
+	}
+
+	update := &api.StatusUpdate{BuildFinished: &api.BuildFinished{}}
+	if failed != 0 {
+		update.BuildFailed = &api.BuildFailed{
+			Error: fmt.Sprintf("%d jobs failed", failed),
+		}
+	}
+
+	return e.update(update)
+}
+
+// skipDependents пропускает все джобы, которые прямо или транзитивно зависят от упавшего,
+// и возвращает число пропущенных джобов.
+//
+// Счётчик зависимостей пропущенного джоба никогда не дойдёт до нуля, поэтому в шедулер он не попадёт.
+func (e *buildExecutor) skipDependents(failedID build.ID) (int, error) {
+	skipped := 0
+
+	queue := []build.ID{failedID}
+	for len(queue) != 0 {
+		jobID := queue[0]
+		queue = queue[1:]
+
+		for _, dependent := range e.dependents[jobID] {
+			if _, ok := e.skipped[dependent]; ok {
+				continue
+			}
+			e.skipped[dependent] = struct{}{}
+			skipped++
+
+			update := &api.StatusUpdate{JobSkipped: &api.JobSkipped{ID: dependent, FailedDep: failedID}}
+			if err := e.update(update); err != nil {
+				return skipped, err
+			}
+
+			queue = append(queue, dependent)
+		}
+	}
+
+	return skipped, nil
+}
+
+// schedule отправляет джоб в шедулер и ждёт его завершения в отдельной горутине.
+func (e *buildExecutor) schedule(ctx context.Context, jobID build.ID) error {
+	job := e.jobs[jobID]
+
+	job.Artifacts = make(map[build.ID]api.WorkerID, len(job.Deps))
+	for _, dep := range job.Deps {
+		workerID, exist := e.sched.LocateArtifact(dep)
+		if !exist {
+			e.l.Error("dependency artifact not found",
+				zap.String("job_id", jobID.String()),
+				zap.String("dep_id", dep.String()))
+			return fmt.Errorf("artifact of job %s not found", dep)
+		}
+
+		job.Artifacts[dep] = workerID
+	}
+
+	pending := e.sched.ScheduleJob(job)
+	e.scheduled[jobID] = struct{}{}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-pending.Finished:
+		}
+
+		result := pending.Result
+		if result == nil {
+			// Джоб завершился до того, как его запланировали, и о нём известен только артефакт.
+			result = &api.JobResult{ID: jobID}
+		}
+
+		select {
+		case <-ctx.Done():
+		case e.finished <- finishedJob{id: jobID, result: result}:
+		}
+	}()
+
+	return nil
+}
+
+func (e *buildExecutor) update(update *api.StatusUpdate) error {
+	if err := e.sw.Updated(update); err != nil {
+		e.l.Error("error when trying to update the build status",
+			zap.Error(err),
+			zap.Any("update", update))
+		return fmt.Errorf("error when trying to update the build status: %w", err)
+	}
+
+	return nil
+}
@@ -21,7 +21,8 @@ func NewHeartbeatService(l *zap.Logger, core *coordinatorCore) *heartbeatService
 }
 
 func (h *heartbeatService) Heartbeat(ctx context.Context, req *api.HeartbeatRequest) (*api.HeartbeatResponse, error) {
-	h.sched.RegisterWorker(req.WorkerID)
+	done := h.sched.RegisterWorker(req.WorkerID)
+	defer done()
 
 	// read worker request
 
@@ -31,7 +32,10 @@ func (h *heartbeatService) Heartbeat(ctx context.Context, req *api.HeartbeatRequ
 	for _, job := range req.FinishedJob {
 		exist := h.sched.OnJobComplete(req.WorkerID, job.ID, &job)
 		if !exist {
-			panic("non schedule job finished")
+			// Например, джоб отменили, пока воркер его исполнял.
+			h.l.Warn("finished job is not scheduled",
+				zap.String("worker_id", req.WorkerID.String()),
+				zap.String("job_id", job.ID.String()))
 		}
 
 		uniq[job.ID] = struct{}{}
@@ -43,17 +47,39 @@ func (h *heartbeatService) Heartbeat(ctx context.Context, req *api.HeartbeatRequ
 		}
 	}
 
+	// Воркер не присылает в RemovedArtifacts и RemovedFiles то, что после вытеснения появилось снова,
+	// поэтому удаления обрабатываются последними.
+	if len(req.RemovedArtifacts) != 0 {
+		h.sched.OnArtifactsRemoved(req.WorkerID, req.RemovedArtifacts)
+	}
+	if len(req.RemovedFiles) != 0 {
+		h.sched.OnFilesRemoved(req.WorkerID, req.RemovedFiles)
+	}
+
 	// write worker responce
 
 	responce := &api.HeartbeatResponse{
-		JobsToRun: make(map[build.ID]api.JobSpec),
+		JobsToRun:  make(map[build.ID]api.JobSpec),
+		CancelJobs: h.sched.CancelledJobs(req.WorkerID),
+	}
+
+	// Воркер должен сразу прислать полный список артефактов, поэтому такой ответ не ждёт джобов.
+	if responce.ResyncArtifacts = h.syncArtifacts(req); responce.ResyncArtifacts {
+		h.l.Debug("write worker responce", zap.Any("responce", responce))
+		return responce, nil
+	}
+
+	// Занятый воркер присылает heartbeat-ы только чтобы сообщить, что он жив.
+	if req.FreeSlots <= 0 {
+		h.l.Debug("write worker responce", zap.Any("responce", responce))
+		return responce, nil
 	}
 
 	pending := h.sched.PickJob(ctx, req.WorkerID)
 	if pending == nil {
 		return nil, ctx.Err()
 	}
-	responce.JobsToRun[pending.Job.ID] = *pending.Job
+	responce.JobsToRun[pending.Job().ID] = *pending.Job()
 	req.FreeSlots--
 
 	for range req.FreeSlots {
@@ -63,7 +89,7 @@ func (h *heartbeatService) Heartbeat(ctx context.Context, req *api.HeartbeatRequ
 				if pending == nil {
 					return nil, ctx.Err()
 				}
-				responce.JobsToRun[pending.Job.ID] = *pending.Job
+				responce.JobsToRun[pending.Job().ID] = *pending.Job()
 				break
 			}
 
@@ -74,3 +100,31 @@ func (h *heartbeatService) Heartbeat(ctx context.Context, req *api.HeartbeatRequ
 
 	return responce, nil
 }
+
+// syncArtifacts сверяет опись кеша артефактов воркера с шедулером. Возвращает true,
+// если воркер должен прислать полный список артефактов.
+func (h *heartbeatService) syncArtifacts(req *api.HeartbeatRequest) bool {
+	inventory := req.Inventory
+	if inventory != nil && inventory.Full {
+		h.sched.SyncArtifacts(req.WorkerID, inventory.Artifacts)
+		return false
+	}
+
+	// Дайджест считается по всем артефактам воркера, поэтому без описи хватает проверить, что они известны.
+	if inventory == nil {
+		return !h.sched.HasArtifacts(req.WorkerID)
+	}
+
+	digest, known := h.sched.ArtifactsDigest(req.WorkerID)
+	if !known {
+		return true
+	}
+
+	if inventory.Digest != digest {
+		h.l.Info("artifact inventory mismatch",
+			zap.String("worker_id", req.WorkerID.String()))
+		return true
+	}
+
+	return false
+}
@@ -3,15 +3,20 @@
 package filecache
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
 
 	"go.uber.org/zap"
 
 	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/codec"
 )
 
 type Client struct {
@@ -28,7 +33,21 @@ func NewClient(l *zap.Logger, endpoint string) *Client {
 	}
 }
 
-func (c *Client) Upload(ctx context.Context, fileID build.ID, localPath string) error {
+// Upload загружает на сервер исходный файл path, лежащий на диске по пути localPath. Сервер проверяет,
+// что id совпадает с build.SourceFileID файла, и иначе отвечает ошибкой.
+//
+// Файл сжимается кодеком codec.Preferred. Если сервер не знает кодек и отвечает 415,
+// файл загружается без сжатия.
+func (c *Client) Upload(ctx context.Context, fileID build.ID, path, localPath string) error {
+	err := c.upload(ctx, fileID, path, localPath, codec.Preferred())
+	if errors.Is(err, codec.ErrUnsupported) {
+		c.l.Warn("server does not support compression, uploading uncompressed", zap.Error(err))
+		err = c.upload(ctx, fileID, path, localPath, nil)
+	}
+	return err
+}
+
+func (c *Client) upload(ctx context.Context, fileID build.ID, path, localPath string, enc codec.Codec) error {
 	file, err := os.Open(localPath)
 	if err != nil {
 		c.l.Error("couldn't open the file", zap.Error(err))
@@ -36,14 +55,36 @@ func (c *Client) Upload(ctx context.Context, fileID build.ID, localPath string)
 	}
 	defer file.Close()
 
-	url := fmt.Sprintf("%s/file?id=%s", c.endpoint, fileID.String())
-	req, err := http.NewRequestWithContext(ctx, "PUT", url, file)
+	// Сжатый файл пишется в pipe параллельно с отправкой запроса. Когда запрос завершается,
+	// клиент закрывает pr, и запись в pw прерывается.
+	pr, pw := io.Pipe()
+	body, err := codec.NewWriter(pw, enc)
 	if err != nil {
+		c.l.Error("failed to create encoder", zap.Error(err))
+		return fmt.Errorf("failed to create encoder: %w", err)
+	}
+
+	go func() {
+		_, err := io.Copy(body, file)
+		if err == nil {
+			err = body.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	query := neturl.Values{"id": {fileID.String()}, "path": {path}}
+	url := fmt.Sprintf("%s/file?%s", c.endpoint, query.Encode())
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, pr)
+	if err != nil {
+		_ = pr.Close()
 		c.l.Error(fmt.Sprintf("failed to creating a PUT %s request", url), zap.Error(err))
 		return fmt.Errorf("error creating a PUT %s request: %w", url, err)
 	}
 
 	req.Header.Set("Content-Type", "application/octet-stream")
+	if enc != nil {
+		req.Header.Set("Content-Encoding", enc.Name())
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -59,14 +100,65 @@ func (c *Client) Upload(ctx context.Context, fileID build.ID, localPath string)
 			return fmt.Errorf("read error body: %w", err)
 		}
 
+		if resp.StatusCode == http.StatusUnsupportedMediaType && enc != nil {
+			return fmt.Errorf("%w: %s", codec.ErrUnsupported, string(body))
+		}
+
 		c.l.Error("unexpected status", zap.Int("status", resp.StatusCode), zap.String("body", string(body)))
 		return fmt.Errorf("unexpected status: %d, Body: %s", resp.StatusCode, string(body))
 	}
 
+	body.Stats().Log(c.l, "file uploaded", zap.String("id", fileID.String()))
 	return nil
 }
 
-func (c *Client) Download(ctx context.Context, localCache *Cache, fileID build.ID) error {
+// Has возвращает те из fileIDs, которые уже есть в кеше на стороне сервера.
+func (c *Client) Has(ctx context.Context, fileIDs []build.ID) ([]build.ID, error) {
+	body, err := json.Marshal(fileIDs)
+	if err != nil {
+		c.l.Error("request body encoding error", zap.Error(err))
+		return nil, fmt.Errorf("request body encoding error: %w", err)
+	}
+
+	url := c.endpoint + "/file/has"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		c.l.Error(fmt.Sprintf("failed to creating a POST %s request", url), zap.Error(err))
+		return nil, fmt.Errorf("error creating a POST %s request: %w", url, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.l.Error("failed to sending the request", zap.Error(err))
+		return nil, fmt.Errorf("error sending the request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			c.l.Error("read error body", zap.Error(err))
+			return nil, fmt.Errorf("read error body: %w", err)
+		}
+
+		c.l.Error("unexpected status", zap.Int("status", resp.StatusCode), zap.String("body", string(body)))
+		return nil, fmt.Errorf("unexpected status: %d, Body: %s", resp.StatusCode, string(body))
+	}
+
+	var present []build.ID
+	if err := json.NewDecoder(resp.Body).Decode(&present); err != nil {
+		c.l.Error("response body decoding error", zap.Error(err))
+		return nil, fmt.Errorf("response body decoding error: %w", err)
+	}
+
+	return present, nil
+}
+
+// Download скачивает исходный файл path в localCache. Если содержимое не совпало с id
+// (см. build.SourceFileID), файл не попадает в кеш и возвращается ErrDigestMismatch.
+func (c *Client) Download(ctx context.Context, localCache *Cache, fileID build.ID, path string) error {
 	url := fmt.Sprintf("%s/file?id=%s", c.endpoint, fileID.String())
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -76,6 +168,7 @@ func (c *Client) Download(ctx context.Context, localCache *Cache, fileID build.I
 	}
 
 	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Accept-Encoding", codec.AcceptEncoding())
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -89,6 +182,13 @@ func (c *Client) Download(ctx context.Context, localCache *Cache, fileID build.I
 		return fmt.Errorf("unexpected status: %s", resp.Status)
 	}
 
+	body, err := codec.DecodeResponse(resp)
+	if err != nil {
+		c.l.Error("failed to decode response", zap.Error(err))
+		return fmt.Errorf("decode response: %w", err)
+	}
+	defer func() { _ = body.Close() }()
+
 	w, abort, err := localCache.Write(fileID)
 	if err != nil {
 		c.l.Error("failed to create cache file", zap.Error(err))
@@ -100,15 +200,26 @@ func (c *Client) Download(ctx context.Context, localCache *Cache, fileID build.I
 		}
 	}()
 
-	if _, err = io.Copy(w, resp.Body); err != nil {
+	hash := build.NewSourceFileHash(path)
+	if _, err = io.Copy(io.MultiWriter(w, hash), body); err != nil {
 		c.l.Error("couldn't copy data to local cache", zap.Error(err))
 		return fmt.Errorf("couldn't copy data to local cache: %w", err)
 	}
 
+	if got := hash.ID(); got != fileID {
+		err = fmt.Errorf("%w: %s has id %s", ErrDigestMismatch, path, got)
+		c.l.Error("downloaded file does not match its id",
+			zap.String("id", fileID.String()),
+			zap.String("path", path),
+			zap.String("content_id", got.String()))
+		return err
+	}
+
 	if err = w.Close(); err != nil {
 		c.l.Error("failed to close writer", zap.Error(err))
 		return fmt.Errorf("close writer: %w", err)
 	}
 
+	body.Stats().Log(c.l, "file downloaded", zap.String("id", fileID.String()))
 	return nil
 }
@@ -3,6 +3,7 @@ package filecache_test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -47,6 +48,13 @@ func newEnv(t *testing.T) *env {
 	return env
 }
 
+// sourceFileID возвращает build.SourceFileID исходного файла path с содержимым content.
+func sourceFileID(path string, content []byte) build.ID {
+	h := build.NewSourceFileHash(path)
+	_, _ = h.Write(content)
+	return h.ID()
+}
+
 func TestFileUpload(t *testing.T) {
 	env := newEnv(t)
 	content := bytes.Repeat([]byte("foobar"), 1024*1024)
@@ -57,9 +65,9 @@ func TestFileUpload(t *testing.T) {
 	ctx := context.Background()
 
 	t.Run("UploadSingleFile", func(t *testing.T) {
-		id := build.ID{0x01}
+		id := sourceFileID("a.txt", content)
 
-		require.NoError(t, env.client.Upload(ctx, id, tmpFilePath))
+		require.NoError(t, env.client.Upload(ctx, id, "a.txt", tmpFilePath))
 
 		path, unlock, err := env.cache.Get(id)
 		require.NoError(t, err)
@@ -71,10 +79,10 @@ func TestFileUpload(t *testing.T) {
 	})
 
 	t.Run("RepeatedUpload", func(t *testing.T) {
-		id := build.ID{0x02}
+		id := sourceFileID("b.txt", content)
 
-		require.NoError(t, env.client.Upload(ctx, id, tmpFilePath))
-		require.NoError(t, env.client.Upload(ctx, id, tmpFilePath))
+		require.NoError(t, env.client.Upload(ctx, id, "b.txt", tmpFilePath))
+		require.NoError(t, env.client.Upload(ctx, id, "b.txt", tmpFilePath))
 	})
 
 	t.Run("ConcurrentUpload", func(t *testing.T) {
@@ -87,12 +95,13 @@ func TestFileUpload(t *testing.T) {
 			var wg sync.WaitGroup
 			wg.Add(G)
 
-			id := build.ID{0x03, byte(i)}
+			path := fmt.Sprintf("c%d.txt", i)
+			id := sourceFileID(path, content)
 			for j := 0; j < G; j++ {
 				go func() {
 					defer wg.Done()
 
-					assert.NoError(t, env.client.Upload(ctx, id, tmpFilePath))
+					assert.NoError(t, env.client.Upload(ctx, id, path, tmpFilePath))
 				}()
 			}
 
@@ -106,7 +115,7 @@ func TestFileDownload(t *testing.T) {
 
 	localCache := newCache(t)
 
-	id := build.ID{0x01}
+	id := sourceFileID("a.txt", []byte("foobar"))
 
 	w, abort, err := env.cache.Write(id)
 	require.NoError(t, err)
@@ -117,7 +126,7 @@ func TestFileDownload(t *testing.T) {
 	require.NoError(t, w.Close())
 
 	ctx := context.Background()
-	require.NoError(t, env.client.Download(ctx, localCache.Cache, id))
+	require.NoError(t, env.client.Download(ctx, localCache.Cache, id, "a.txt"))
 
 	path, unlock, err := localCache.Get(id)
 	require.NoError(t, err)
@@ -127,3 +136,143 @@ func TestFileDownload(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []byte("foobar"), content)
 }
+
+func TestFileHas(t *testing.T) {
+	env := newEnv(t)
+
+	id := build.ID{0x01}
+
+	w, abort, err := env.cache.Write(id)
+	require.NoError(t, err)
+	defer func() { _ = abort() }()
+
+	_, err = w.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	ctx := context.Background()
+
+	present, err := env.client.Has(ctx, []build.ID{{0x01}, {0x02}})
+	require.NoError(t, err)
+	require.Equal(t, []build.ID{id}, present)
+
+	present, err = env.client.Has(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, present)
+
+	rsp, err := http.Post(env.server.URL+"/file/has", "application/json", bytes.NewReader([]byte("{")))
+	require.NoError(t, err)
+	_ = rsp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
+
+func TestFileUploadUncompressedFallback(t *testing.T) {
+	cache := newCache(t)
+
+	l := zaptest.NewLogger(t)
+	mux := http.NewServeMux()
+	filecache.NewHandler(l, cache.Cache).Register(mux)
+
+	// Сервер, который не знает ни одного кодека.
+	var encodings []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		mu.Unlock()
+
+		if r.Header.Get("Content-Encoding") != "" {
+			http.Error(w, "unsupported encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	content := []byte("foobar")
+	tmpFilePath := filepath.Join(cache.tmpDir, "foo.txt")
+	require.NoError(t, os.WriteFile(tmpFilePath, content, 0666))
+
+	id := sourceFileID("foo.txt", content)
+	require.NoError(t, filecache.NewClient(l, server.URL).Upload(context.Background(), id, "foo.txt", tmpFilePath))
+	require.Equal(t, []string{"gzip", ""}, encodings)
+
+	path, unlock, err := cache.Get(id)
+	require.NoError(t, err)
+	defer unlock()
+
+	actualContent, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, actualContent)
+}
+
+func TestFileUploadDigestMismatch(t *testing.T) {
+	env := newEnv(t)
+
+	tmpFilePath := filepath.Join(env.cache.tmpDir, "foo.txt")
+	require.NoError(t, os.WriteFile(tmpFilePath, []byte("foobar"), 0666))
+
+	ctx := context.Background()
+
+	// Тот же файл под другим путём имеет другой id.
+	id := sourceFileID("foo.txt", []byte("foobar"))
+	require.Error(t, env.client.Upload(ctx, id, "bar.txt", tmpFilePath))
+	require.False(t, env.cache.Has(id))
+
+	require.Error(t, env.client.Upload(ctx, build.ID{0x01}, "foo.txt", tmpFilePath))
+	require.False(t, env.cache.Has(build.ID{0x01}))
+}
+
+func TestFileUploadExisting(t *testing.T) {
+	env := newEnv(t)
+
+	goodPath := filepath.Join(env.cache.tmpDir, "good.txt")
+	require.NoError(t, os.WriteFile(goodPath, []byte("foobar"), 0666))
+	badPath := filepath.Join(env.cache.tmpDir, "bad.txt")
+	require.NoError(t, os.WriteFile(badPath, []byte("fooba"), 0666))
+
+	ctx := context.Background()
+	id := sourceFileID("a.txt", []byte("foobar"))
+	require.NoError(t, env.client.Upload(ctx, id, "a.txt", goodPath))
+
+	// Файл читают, пока его загружают повторно, в том числе с испорченным содержимым.
+	path, unlock, err := env.cache.Get(id)
+	require.NoError(t, err)
+
+	require.NoError(t, env.client.Upload(ctx, id, "a.txt", goodPath))
+	require.NoError(t, env.client.Upload(ctx, id, "a.txt", badPath))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "foobar", string(content))
+	unlock()
+
+	path, unlock, err = env.cache.Get(id)
+	require.NoError(t, err)
+	defer unlock()
+
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "foobar", string(content))
+}
+
+func TestFileDownloadDigestMismatch(t *testing.T) {
+	env := newEnv(t)
+
+	localCache := newCache(t)
+
+	// Файл на сервере испорчен: его содержимое не совпадает с id.
+	id := sourceFileID("a.txt", []byte("foobar"))
+
+	w, abort, err := env.cache.Write(id)
+	require.NoError(t, err)
+	defer func() { _ = abort() }()
+
+	_, err = w.Write([]byte("fooba"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	err = env.client.Download(context.Background(), localCache.Cache, id, "a.txt")
+	require.ErrorIs(t, err, filecache.ErrDigestMismatch)
+	require.False(t, localCache.Has(id))
+}
@@ -5,16 +5,19 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"gitlab.com/justnurik/distbuild/pkg/artifact"
 	"gitlab.com/justnurik/distbuild/pkg/build"
 )
 
 var (
-	ErrNotFound    = errors.New("file not found")
-	ErrExists      = errors.New("file exists")
-	ErrWriteLocked = errors.New("file is locked for write")
-	ErrReadLocked  = errors.New("file is locked for read")
+	ErrNotFound       = errors.New("file not found")
+	ErrExists         = errors.New("file exists")
+	ErrWriteLocked    = errors.New("file is locked for write")
+	ErrReadLocked     = errors.New("file is locked for read")
+	ErrDigestMismatch = errors.New("file content does not match its id")
 )
 
 const fileName = "file"
@@ -36,18 +39,54 @@ func convertErr(err error) error {
 
 type Cache struct {
 	cache *artifact.Cache
+
+	mu       sync.Mutex
+	pins     map[build.ID]int
+	lastUsed map[build.ID]time.Time
+}
+
+// Config задаёт параметры кеша файлов.
+type Config struct {
+	// MaxSize ограничивает суммарный размер файлов в байтах. Когда кеш его превышает, давно не
+	// использованные незакреплённые файлы вытесняются. Если MaxSize == 0, кеш не ограничен.
+	MaxSize int64
 }
 
 func New(rootDir string) (*Cache, error) {
-	cache, err := artifact.NewCache(rootDir)
+	return NewWithConfig(rootDir, Config{})
+}
+
+func NewWithConfig(rootDir string, config Config) (*Cache, error) {
+	c := &Cache{
+		pins:     make(map[build.ID]int),
+		lastUsed: make(map[build.ID]time.Time),
+	}
+
+	cache, err := artifact.NewCacheWithConfig(rootDir, artifact.Config{
+		MaxSize: config.MaxSize,
+
+		// Pin под тем же локом, поэтому файл, закреплённый и найденный в кеше, уже не вытесняется.
+		CanEvict:  c.unpinned,
+		EvictLock: &c.mu,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	c := &Cache{cache: cache}
+	c.cache = cache
 	return c, nil
 }
 
+// OnEvict задаёт функцию, которую кеш вызывает после вытеснения каждого файла.
+func (c *Cache) OnEvict(fn func(file build.ID)) {
+	c.cache.OnEvict(fn)
+}
+
+// Stats возвращает заполненность кеша и статистику вытеснений.
+func (c *Cache) Stats() artifact.Stats {
+	return c.cache.Stats()
+}
+
 func (c *Cache) Range(fileFn func(file build.ID) error) error {
 	return c.cache.Range(fileFn)
 }
@@ -109,3 +148,14 @@ func (c *Cache) Get(file build.ID) (path string, unlock func(), err error) {
 	err = convertErr(err)
 	return
 }
+
+// Has сообщает, есть ли в кеше полностью записанный файл.
+func (c *Cache) Has(file build.ID) bool {
+	_, unlock, err := c.Get(file)
+	if err != nil {
+		return false
+	}
+
+	unlock()
+	return true
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/justnurik/distbuild/pkg/build"
@@ -57,3 +59,145 @@ func TestFileCache(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []byte("foo bar"), content)
 }
+
+func writeFile(t *testing.T, cache *testCache, id build.ID) {
+	f, _, err := cache.Write(id)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("foo bar"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestFileCachePinAndCollect(t *testing.T) {
+	cache := newCache(t)
+
+	writeFile(t, cache, build.ID{01})
+	writeFile(t, cache, build.ID{02})
+
+	releaseA := cache.Pin([]build.ID{{01}})
+	releaseB := cache.Pin([]build.ID{{01}, {02}})
+
+	// Первый проход только замечает незнакомые файлы.
+	removed, err := cache.Collect(0)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	releaseB()
+	releaseB()
+
+	removed, err = cache.Collect(0)
+	require.NoError(t, err)
+	require.Equal(t, []build.ID{{02}}, removed)
+	require.True(t, cache.Has(build.ID{01}))
+	require.False(t, cache.Has(build.ID{02}))
+
+	releaseA()
+
+	removed, err = cache.Collect(time.Hour)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+	require.True(t, cache.Has(build.ID{01}))
+
+	removed, err = cache.Collect(0)
+	require.NoError(t, err)
+	require.Equal(t, []build.ID{{01}}, removed)
+}
+
+func TestFileCacheCollectOrphans(t *testing.T) {
+	cache := newCache(t)
+
+	writeFile(t, cache, build.ID{01})
+
+	removed, err := cache.Collect(0)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	removed, err = cache.Collect(0)
+	require.NoError(t, err)
+	require.Equal(t, []build.ID{{01}}, removed)
+}
+
+func TestFileCacheCollectLockedFile(t *testing.T) {
+	cache := newCache(t)
+
+	writeFile(t, cache, build.ID{01})
+
+	removed, err := cache.Collect(0)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Файл читают, поэтому его пока нельзя удалить.
+	_, unlock, err := cache.Get(build.ID{01})
+	require.NoError(t, err)
+
+	removed, err = cache.Collect(10 * time.Millisecond)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	unlock()
+
+	// Время использования не сбросилось, и файл удаляется сразу, как только его перестали читать.
+	removed, err = cache.Collect(10 * time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, []build.ID{{01}}, removed)
+}
+
+func TestFileCacheEvictConcurrentPin(t *testing.T) {
+	c, err := filecache.NewWithConfig(t.TempDir(), filecache.Config{MaxSize: 10})
+	require.NoError(t, err)
+	cache := &testCache{Cache: c}
+
+	target := build.ID{01}
+	for i := 0; i < 200; i++ {
+		if !cache.Has(target) {
+			writeFile(t, cache, target)
+		}
+
+		// Новый файл не помещается в кеш вместе с target и вытесняет его, если тот не закреплён.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			f, _, err := cache.Write(build.ID{02, byte(i), byte(i >> 8)})
+			if assert.NoError(t, err) {
+				_, err = f.Write([]byte("foo bar"))
+				assert.NoError(t, err)
+				assert.NoError(t, f.Close())
+			}
+		}()
+
+		release := cache.Pin([]build.ID{target})
+		pinned := cache.Has(target)
+		<-done
+
+		// Файл, который после Pin был в кеше, остаётся в нём до release.
+		if pinned {
+			require.True(t, cache.Has(target), "pinned file was evicted")
+		}
+		release()
+	}
+}
+
+func TestFileCacheEvictKeepsPinned(t *testing.T) {
+	c, err := filecache.NewWithConfig(t.TempDir(), filecache.Config{MaxSize: 10})
+	require.NoError(t, err)
+	cache := &testCache{Cache: c}
+
+	writeFile(t, cache, build.ID{01})
+	release := cache.Pin([]build.ID{{01}})
+
+	// Закреплённый файл не вытесняется, даже если он самый старый.
+	writeFile(t, cache, build.ID{02})
+	require.True(t, cache.Has(build.ID{01}))
+	require.True(t, cache.Has(build.ID{02}))
+
+	release()
+
+	writeFile(t, cache, build.ID{03})
+	require.False(t, cache.Has(build.ID{01}))
+	require.False(t, cache.Has(build.ID{02}))
+	require.True(t, cache.Has(build.ID{03}))
+	require.Equal(t, int64(2), cache.Stats().Evicted)
+}
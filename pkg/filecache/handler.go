@@ -3,6 +3,8 @@
 package filecache
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +12,7 @@ import (
 	"sync"
 
 	"gitlab.com/justnurik/distbuild/pkg/build"
+	"gitlab.com/justnurik/distbuild/pkg/codec"
 	"gitlab.com/justnurik/distbuild/pkg/concurrency"
 	"go.uber.org/zap"
 	"golang.org/x/sync/singleflight"
@@ -31,6 +34,7 @@ func NewHandler(l *zap.Logger, cache *Cache) *Handler {
 }
 
 func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/file/has", h.handleHas)
 	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
 		strID := r.URL.Query().Get("id")
 		if strID == "" {
@@ -51,7 +55,7 @@ func (h *Handler) Register(mux *http.ServeMux) {
 		switch r.Method {
 
 		case http.MethodGet:
-			h.handleGet(fileID, w)
+			h.handleGet(fileID, w, r)
 
 		case http.MethodPut:
 			h.handlePut(fileID, w, r)
@@ -64,7 +68,35 @@ func (h *Handler) Register(mux *http.ServeMux) {
 	})
 }
 
-func (h *Handler) handleGet(fileID build.ID, w http.ResponseWriter) {
+// handleHas принимает json-список id и отвечает json-списком тех из них, которые есть в кеше.
+func (h *Handler) handleHas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.l.Error("unsupported method", zap.String("method", r.Method))
+		http.Error(w, "POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var fileIDs []build.ID
+	if err := json.NewDecoder(r.Body).Decode(&fileIDs); err != nil {
+		h.l.Error("request body decoding error", zap.Error(err))
+		http.Error(w, fmt.Sprintf("request body decoding error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	present := make([]build.ID, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		if h.cache.Has(fileID) {
+			present = append(present, fileID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(present); err != nil {
+		h.l.Error("couldn't write the response", zap.Error(err))
+	}
+}
+
+func (h *Handler) handleGet(fileID build.ID, w http.ResponseWriter, r *http.Request) {
 	_, err, _ := h.flight.Do(fileID.String(), func() (any, error) {
 		path, unlock, err := h.cache.Get(fileID)
 		if err != nil {
@@ -84,7 +116,13 @@ func (h *Handler) handleGet(fileID build.ID, w http.ResponseWriter) {
 
 		w.Header().Set("Content-Type", "application/octet-stream")
 
-		if n, err := io.Copy(w, file); err != nil {
+		body, err := codec.EncodeResponse(w, r)
+		if err != nil {
+			h.l.Error("failed to create encoder", zap.Error(err))
+			return nil, fmt.Errorf("failed to create encoder: %w", err)
+		}
+
+		if n, err := io.Copy(body, file); err != nil {
 			h.l.Error("couldn't copy the file to the request body",
 				zap.String("id", fileID.String()),
 				zap.Error(err),
@@ -92,6 +130,14 @@ func (h *Handler) handleGet(fileID build.ID, w http.ResponseWriter) {
 			return nil, fmt.Errorf("couldn't copy the file to the request body: %w", err)
 		}
 
+		if err := body.Close(); err != nil {
+			h.l.Error("couldn't finish the response body",
+				zap.String("id", fileID.String()),
+				zap.Error(err))
+			return nil, fmt.Errorf("couldn't finish the response body: %w", err)
+		}
+
+		body.Stats().Log(h.l, "file sent", zap.String("id", fileID.String()))
 		return nil, nil
 	})
 	if err != nil {
@@ -100,37 +146,39 @@ func (h *Handler) handleGet(fileID build.ID, w http.ResponseWriter) {
 	}
 }
 
+// handlePut принимает содержимое исходного файла. Query-параметр path - путь файла в графе: id файла
+// вычисляется от пути и содержимого (build.SourceFileID), и файл, не совпавший со своим id, не попадает в кеш.
 func (h *Handler) handlePut(fileID build.ID, w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		h.l.Error("file path required", zap.String("id", fileID.String()))
+		http.Error(w, "file path required", http.StatusBadRequest)
+		return
+	}
+
+	body, err := codec.DecodeRequest(r)
+	if err != nil {
+		h.l.Error("unsupported request encoding", zap.Error(err))
+		w.Header().Set("Accept-Encoding", codec.AcceptEncoding())
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	defer func() { _ = body.Close() }()
+
 	h.lock.Lock(fileID)
 	defer h.lock.Unlock(fileID)
 
-start:
 	writer, abort, err := h.cache.Write(fileID)
-
-	switch err {
-
-	case ErrExists:
-
-		if err := h.cache.Remove(fileID); err != nil {
-			h.l.Error("couldn't delete the file to update the data",
-				zap.String("id", fileID.String()),
-				zap.Error(err))
-			http.Error(w, fmt.Errorf("couldn't delete the file to update the data: %w", err).Error(), http.StatusInternalServerError)
-			return
+	switch {
+	case errors.Is(err, ErrExists):
+		// Id файла - хеш его пути и содержимого, поэтому в кеше уже лежит то же самое. Старый файл не
+		// удаляется: его могут читать, а новая загрузка может оказаться битой.
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			h.l.Warn("couldn't read the body of a duplicate upload", zap.String("id", fileID.String()), zap.Error(err))
 		}
+		return
 
-		goto start //! one-shot
-
-	case nil:
-
-		defer func() {
-			if err != nil {
-				_ = abort()
-			}
-		}()
-
-	default:
-
+	case err != nil:
 		h.l.Error("failed to create a cache entry",
 			zap.String("id", fileID.String()),
 			zap.Error(err))
@@ -138,16 +186,34 @@ start:
 		return
 	}
 
-	if _, err = io.Copy(writer, r.Body); err != nil {
+	defer func() {
+		if err != nil {
+			_ = abort()
+		}
+	}()
+
+	hash := build.NewSourceFileHash(path)
+	if _, err = io.Copy(io.MultiWriter(writer, hash), body); err != nil {
 		h.l.Error("couldn't copy data to cache", zap.Error(err))
 		http.Error(w, fmt.Errorf("couldn't copy data to cache: %w", err).Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if got := hash.ID(); got != fileID {
+		err = fmt.Errorf("%w: %s has id %s", ErrDigestMismatch, path, got)
+		h.l.Error("uploaded file does not match its id",
+			zap.String("id", fileID.String()),
+			zap.String("path", path),
+			zap.String("content_id", got.String()))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if err = writer.Close(); err != nil {
 		h.l.Error("failed to close writer", zap.Error(err))
 		http.Error(w, fmt.Errorf("close writer: %w", err).Error(), http.StatusInternalServerError)
+		return
 	}
 
-	h.l.Info("file successfully uploaded", zap.String("id", fileID.String()))
+	body.Stats().Log(h.l, "file successfully uploaded", zap.String("id", fileID.String()))
 }
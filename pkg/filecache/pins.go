@@ -0,0 +1,101 @@
+package filecache
+
+import (
+	"errors"
+	"time"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// Pin защищает файлы от удаления сборщиком мусора и от вытеснения, пока не будет вызван release.
+//
+// Файл можно закрепить несколько раз, он становится свободным, когда снят последний pin.
+// Закреплять можно и файлы, которых ещё нет в кеше. Файл, который есть в кеше после возврата из Pin,
+// останется в нём до release.
+func (c *Cache) Pin(files []build.ID) (release func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, file := range files {
+		c.pins[file]++
+	}
+
+	released := false
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if released {
+			return
+		}
+		released = true
+
+		now := time.Now()
+		for _, file := range files {
+			c.pins[file]--
+			if c.pins[file] == 0 {
+				delete(c.pins, file)
+				c.lastUsed[file] = now
+			}
+		}
+	}
+}
+
+// unpinned сообщает, что файл можно вытеснить из кеша. Вызывается под c.mu.
+func (c *Cache) unpinned(file build.ID) bool {
+	return c.pins[file] == 0
+}
+
+// Collect удаляет из кеша незакреплённые файлы, которые не использовались дольше ttl,
+// и возвращает их id.
+//
+// Время последнего использования файла - момент снятия последнего pin. Файлы, про которые
+// кеш ничего не знает (например, залитые до рестарта или для билда, который так и не начался),
+// считаются использованными в момент первого вызова Collect, который их увидел.
+func (c *Cache) Collect(ttl time.Duration) ([]build.ID, error) {
+	var files []build.ID
+	if err := c.Range(func(file build.ID) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	var removed []build.ID
+	for _, file := range files {
+		if c.pins[file] != 0 {
+			continue
+		}
+
+		lastUsed, ok := c.lastUsed[file]
+		if !ok {
+			c.lastUsed[file] = now
+			continue
+		}
+
+		if now.Sub(lastUsed) < ttl {
+			continue
+		}
+
+		// Файл, который сейчас читают или пишут, удалим в следующий раз: его время использования
+		// остаётся прежним, чтобы следующий Collect не начал отсчёт заново.
+		err := c.Remove(file)
+		switch {
+		case err == nil:
+			removed = append(removed, file)
+		case errors.Is(err, ErrReadLocked), errors.Is(err, ErrWriteLocked):
+			continue
+		case !errors.Is(err, ErrNotFound):
+			return removed, err
+		}
+
+		delete(c.lastUsed, file)
+	}
+
+	return removed, nil
+}
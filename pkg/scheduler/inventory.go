@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"maps"
+	"slices"
+
+	"go.uber.org/zap"
+
+	"gitlab.com/justnurik/distbuild/pkg/api"
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// ArtifactsDigest возвращает дайджест артефактов, которые, по данным шедулера, лежат в кеше воркера.
+//
+// known == false, если воркер ещё не присылал полный список артефактов (или был признан мёртвым после этого).
+func (c *Scheduler) ArtifactsDigest(workerID api.WorkerID) (digest build.ID, known bool) {
+	c.artifactsMu.RLock()
+	defer c.artifactsMu.RUnlock()
+
+	inventory, known := c.inventories[workerID]
+	if !known {
+		return build.ID{}, false
+	}
+
+	return api.InventoryDigest(slices.Collect(maps.Keys(inventory))), true
+}
+
+// HasArtifacts возвращает true, если воркер уже присылал полный список артефактов, как known у ArtifactsDigest,
+// но не считает дайджест.
+func (c *Scheduler) HasArtifacts(workerID api.WorkerID) bool {
+	c.artifactsMu.RLock()
+	defer c.artifactsMu.RUnlock()
+
+	_, known := c.inventories[workerID]
+	return known
+}
+
+// SyncArtifacts заменяет известные шедулеру артефакты воркера полным списком artifacts из его кеша.
+//
+// Джобы шедулера SyncArtifacts не трогает: джоб из списка, когда его запланируют, попадёт в локальную очередь
+// этого воркера, а воркер воспроизведёт сохранённый результат.
+func (c *Scheduler) SyncArtifacts(workerID api.WorkerID, artifacts []build.ID) {
+	c.checkIsStop("call `SyncArtifacts` after stop scheduling")
+
+	c.l.Info("sync artifacts",
+		zap.String("worker_id", workerID.String()),
+		zap.Int("artifacts", len(artifacts)))
+
+	inventory := make(map[build.ID]struct{}, len(artifacts))
+
+	c.artifactsMu.Lock()
+	defer c.artifactsMu.Unlock()
+
+	removeLocations(c.artifacts, workerID)
+	for _, jobID := range artifacts {
+		inventory[jobID] = struct{}{}
+		addLocation(c.artifacts, jobID, workerID)
+	}
+
+	c.inventories[workerID] = inventory
+}
+
+// OnArtifactsRemoved сообщает, что артефакты больше не лежат в кеше воркера.
+func (c *Scheduler) OnArtifactsRemoved(workerID api.WorkerID, artifacts []build.ID) {
+	c.checkIsStop("call `OnArtifactsRemoved` after stop scheduling")
+
+	c.l.Info("artifacts removed",
+		zap.String("worker_id", workerID.String()),
+		zap.Int("artifacts", len(artifacts)))
+
+	c.artifactsMu.Lock()
+	defer c.artifactsMu.Unlock()
+
+	inventory := c.inventories[workerID]
+	for _, jobID := range artifacts {
+		removeLocation(c.artifacts, jobID, workerID)
+		delete(inventory, jobID)
+	}
+}
+
+// OnFilesRemoved сообщает, что исходные файлы больше не лежат в кеше воркера.
+func (c *Scheduler) OnFilesRemoved(workerID api.WorkerID, files []build.ID) {
+	c.checkIsStop("call `OnFilesRemoved` after stop scheduling")
+
+	c.l.Info("files removed",
+		zap.String("worker_id", workerID.String()),
+		zap.Int("files", len(files)))
+
+	c.artifactsMu.Lock()
+	defer c.artifactsMu.Unlock()
+
+	for _, fileID := range files {
+		removeLocation(c.files, fileID, workerID)
+	}
+}
@@ -17,16 +17,46 @@ import (
 )
 
 type PendingJob struct {
-	Job      *api.JobSpec
+	// job подменяется целиком, когда джоб возвращается в очереди после смерти воркера,
+	// поэтому его читают через Job.
+	job      atomic.Pointer[api.JobSpec]
 	Finished chan struct{}
 	Result   *api.JobResult
 
 	isCloseFinished atomic.Bool
+	isPicked        atomic.Bool
+
+	// refs считает билды, которые ждут этот джоб. Защищено Scheduler.jobsMu.
+	refs int
+}
+
+// Job возвращает спецификацию джоба.
+func (p *PendingJob) Job() *api.JobSpec {
+	return p.job.Load()
 }
 
 type Config struct {
 	CacheTimeout time.Duration
 	DepsTimeout  time.Duration
+
+	// WorkerTimeout задаёт, сколько воркер может молчать, прежде чем шедулер посчитает его мёртвым.
+	//
+	// Если WorkerTimeout == 0, воркеры никогда не считаются мёртвыми.
+	WorkerTimeout time.Duration
+}
+
+// WorkerState описывает состояние воркера с точки зрения шедулера.
+type WorkerState struct {
+	ID api.WorkerID
+
+	// Alive сообщает, что воркер присылал heartbeat-ы последние WorkerTimeout.
+	Alive bool
+
+	// LastSeen задаёт время последнего heartbeat-а.
+	LastSeen time.Time
+
+	// Jobs перечисляет джобы, которые воркер взял и ещё не завершил.
+	Jobs []build.ID
 }
 
 type Queue[T any] interface {
@@ -34,52 +64,104 @@ type Queue[T any] interface {
 	Pop() <-chan T
 }
 
+// workerQueues хранит локальные очереди воркера.
+type workerQueues struct {
+	// cached содержит джобы, результат которых уже есть в кеше воркера.
+	cached Queue[*PendingJob]
+
+	// deps содержит джобы, часть зависимостей или исходных файлов которых уже есть на воркере.
+	deps Queue[*PendingJob]
+}
+
+// workerInfo хранит состояние воркера. Все поля, кроме очередей, защищены Scheduler.workersMu.
+type workerInfo struct {
+	*workerQueues
+
+	alive    bool
+	lastSeen time.Time
+
+	// heartbeats считает heartbeat-ы, которые сейчас обрабатываются.
+	// Пока хотя бы один из них не завершился, воркер считается живым.
+	heartbeats int
+
+	// running хранит джобы, которые воркер взял и ещё не завершил.
+	running map[build.ID]*PendingJob
+
+	// cancelled хранит отменённые джобы из running, о которых воркеру ещё не сообщили.
+	cancelled []build.ID
+
+	// touch будит горутину, которая следит за таймаутом воркера.
+	touch chan struct{}
+}
+
 type Scheduler struct {
 	l *zap.Logger
 
+	config    Config
+	timeAfter func(d time.Duration) <-chan time.Time
+
 	isStop chan struct{}
+	wg     sync.WaitGroup
 	queue  Queue[*PendingJob]
 
+	workers   map[api.WorkerID]*workerInfo
+	workersMu sync.Mutex
+
 	jobs   map[build.ID]*PendingJob
 	jobsMu sync.Mutex
 
 	artifacts   map[build.ID][]api.WorkerID
+	files       map[build.ID][]api.WorkerID
 	artifactsMu sync.RWMutex
+
+	// inventories хранит полные списки артефактов в кешах воркеров, присланные через SyncArtifacts.
+	// Защищено artifactsMu.
+	inventories map[api.WorkerID]map[build.ID]struct{}
 }
 
 type workerCache = []api.WorkerID
 
-func NewScheduler(l *zap.Logger, config Config, timeAfter func(d time.Duration) <-chan time.Time) *Scheduler {
-	_ = config    // ignore
-	_ = timeAfter // ignore
+// maxLocations ограничивает число воркеров, которые запоминаются для одного артефакта или файла.
+const maxLocations = 4
 
+func NewScheduler(l *zap.Logger, config Config, timeAfter func(d time.Duration) <-chan time.Time) *Scheduler {
 	return &Scheduler{
 		l:         l.With(zap.String("component", "scheduler")),
+		config:    config,
+		timeAfter: timeAfter,
 		queue:     newChanQueue[*PendingJob](),
+		workers:   make(map[api.WorkerID]*workerInfo),
 		jobs:      make(map[build.ID]*PendingJob),
 		artifacts: make(map[build.ID]workerCache),
+		files:     make(map[build.ID]workerCache),
 		isStop:    make(chan struct{}),
+
+		inventories: make(map[api.WorkerID]map[build.ID]struct{}),
 	}
 }
 
 func (c *Scheduler) LocateArtifact(id build.ID) (api.WorkerID, bool) {
 	c.checkIsStop("call `LocateArtifact` after stop scheduling")
 
-	c.artifactsMu.RLock()
-	defer c.artifactsMu.RUnlock()
+	workerID, exist := c.locateArtifact(id)
 
-	workersID, exist := c.artifacts[id]
-
-	defer c.l.Info("locate_artifact",
-		zap.Any("workers_id", workersID),
+	c.l.Info("locate_artifact",
+		zap.String("worker_id", workerID.String()),
 		zap.Bool("exist", exist))
 
+	return workerID, exist
+}
+
+func (c *Scheduler) locateArtifact(id build.ID) (api.WorkerID, bool) {
+	c.artifactsMu.RLock()
+	defer c.artifactsMu.RUnlock()
+
+	workersID := c.artifacts[id]
 	if len(workersID) == 0 {
 		return api.WorkerID(""), false
 	}
 
-	workerID := workersID[rand.Intn(len(workersID))]
-	return workerID, exist
+	return workersID[rand.Intn(len(workersID))], true
 }
 
 func (c *Scheduler) OnJobComplete(workerID api.WorkerID, jobID build.ID, res *api.JobResult) bool {
@@ -93,39 +175,73 @@ func (c *Scheduler) OnJobComplete(workerID api.WorkerID, jobID build.ID, res *ap
 		zap.Any("job result", res),
 		zap.Any("jobs", c.jobs[jobID]))
 
+	// Упавший джоб не оставляет артефакта, а следующий билд должен запустить его заново.
+	failed := res != nil && res.Error != nil
+
 	pendingJob, exist := c.jobs[jobID]
+	if !exist && failed {
+		c.jobsMu.Unlock()
+		return false
+	}
 	if !exist {
 		pendingJob = &PendingJob{
-			Job: &api.JobSpec{
-				Job: build.Job{ID: jobID},
-			},
 			Finished: make(chan struct{}),
 			Result:   res,
 		}
+		pendingJob.job.Store(&api.JobSpec{
+			Job: build.Job{ID: jobID},
+		})
 
 		c.jobs[jobID] = pendingJob
 	}
 	if pendingJob.isCloseFinished.CompareAndSwap(false, true) {
 		pendingJob.Result = res
 		close(pendingJob.Finished)
+
+		if failed {
+			delete(c.jobs, jobID)
+		}
 	}
 	c.jobsMu.Unlock()
 
+	c.workersMu.Lock()
+	if w, exist := c.workers[workerID]; exist {
+		delete(w.running, jobID)
+	}
+	c.workersMu.Unlock()
+
 	c.artifactsMu.Lock()
 
-	workersID := c.artifacts[jobID]
-	workersID = append(workersID, workerID)
-	if len(workersID) == 4+1 {
-		workersID = workersID[1:]
+	if !failed {
+		addLocation(c.artifacts, jobID, workerID)
+
+		if inventory, exist := c.inventories[workerID]; exist {
+			inventory[jobID] = struct{}{}
+		}
+	}
+	for fileID := range pendingJob.Job().SourceFiles {
+		addLocation(c.files, fileID, workerID)
 	}
-	c.artifacts[jobID] = workersID
 
 	c.artifactsMu.Unlock()
 
 	return exist
 }
 
-func (c *Scheduler) RegisterWorker(api.WorkerID) {}
+func addLocation(locations map[build.ID]workerCache, id build.ID, workerID api.WorkerID) {
+	workersID := locations[id]
+	for _, w := range workersID {
+		if w == workerID {
+			return
+		}
+	}
+
+	workersID = append(workersID, workerID)
+	if len(workersID) == maxLocations+1 {
+		workersID = workersID[1:]
+	}
+	locations[id] = workersID
+}
 
 func (c *Scheduler) ScheduleJob(job *api.JobSpec) *PendingJob {
 	c.checkIsStop("call `ScheduleJob` after stop scheduling")
@@ -133,53 +249,249 @@ func (c *Scheduler) ScheduleJob(job *api.JobSpec) *PendingJob {
 
 	c.jobsMu.Lock()
 	if p, exist := c.jobs[job.ID]; exist {
+		p.refs++
 		c.jobsMu.Unlock()
 		return p
 	}
 
 	item := &PendingJob{
-		Job:      job,
 		Finished: make(chan struct{}),
 		Result:   nil,
+		refs:     1,
 	}
-
-	c.queue.Push(item)
+	item.job.Store(job)
 
 	c.jobs[job.ID] = item
 	c.jobsMu.Unlock()
 
+	c.enqueue(item)
+
 	return item
 }
 
+// CancelJob сообщает, что один из билдов больше не ждёт джоб.
+//
+// Джоб отменяется, только когда его не ждёт ни один билд. Отменённый джоб завершается с ошибкой
+// и больше не выдаётся воркерам. Если джоб уже исполняется, воркер получит его в CancelledJobs.
+// CancelJob возвращает true, если джоб был отменён.
+func (c *Scheduler) CancelJob(jobID build.ID) bool {
+	c.jobsMu.Lock()
+
+	pending, exist := c.jobs[jobID]
+	if !exist || pending.isCloseFinished.Load() {
+		c.jobsMu.Unlock()
+		return false
+	}
+
+	pending.refs--
+	if pending.refs > 0 {
+		c.jobsMu.Unlock()
+		return false
+	}
+
+	// Следующий ScheduleJob этого джоба начнёт его заново.
+	delete(c.jobs, jobID)
+
+	cancelled := pending.isCloseFinished.CompareAndSwap(false, true)
+	if cancelled {
+		errMsg := "job cancelled"
+		pending.Result = &api.JobResult{ID: jobID, Error: &errMsg}
+		close(pending.Finished)
+	}
+	c.jobsMu.Unlock()
+
+	if !cancelled {
+		return false
+	}
+
+	c.l.Info("cancel job", zap.String("job_id", jobID.String()))
+
+	c.workersMu.Lock()
+	for _, w := range c.workers {
+		if _, exist := w.running[jobID]; exist {
+			delete(w.running, jobID)
+			w.cancelled = append(w.cancelled, jobID)
+		}
+	}
+	c.workersMu.Unlock()
+
+	return true
+}
+
+// enqueue раскладывает джоб по очередям.
+//
+// Сначала джоб попадает в первые локальные очереди воркеров, у которых он уже есть в кеше.
+// Через CacheTimeout (или сразу, если джоба нет в кеше) джоб попадает во вторые локальные очереди
+// воркеров, у которых есть его зависимости или исходные файлы. Через DepsTimeout после этого
+// (или сразу, если таких воркеров нет) джоб попадает в глобальную очередь.
+func (c *Scheduler) enqueue(pending *PendingJob) {
+	cachedOn, depsOn := c.locate(pending.Job())
+
+	if len(cachedOn) == 0 {
+		c.enqueueDeps(pending, depsOn)
+		return
+	}
+
+	for _, workerID := range cachedOn {
+		c.workerQueues(workerID).cached.Push(pending)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		if c.wait(pending, c.config.CacheTimeout) {
+			c.enqueueDeps(pending, depsOn)
+		}
+	}()
+}
+
+func (c *Scheduler) enqueueDeps(pending *PendingJob, depsOn []api.WorkerID) {
+	if len(depsOn) == 0 {
+		c.queue.Push(pending)
+		return
+	}
+
+	for _, workerID := range depsOn {
+		c.workerQueues(workerID).deps.Push(pending)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		if c.wait(pending, c.config.DepsTimeout) {
+			c.queue.Push(pending)
+		}
+	}()
+}
+
+// locate возвращает воркеров, у которых есть результат джоба, и воркеров,
+// у которых есть хотя бы одна его зависимость или исходный файл.
+func (c *Scheduler) locate(job *api.JobSpec) (cachedOn, depsOn []api.WorkerID) {
+	c.artifactsMu.RLock()
+	defer c.artifactsMu.RUnlock()
+
+	cachedOn = append(cachedOn, c.artifacts[job.ID]...)
+
+	uniq := make(map[api.WorkerID]struct{})
+	add := func(workersID []api.WorkerID) {
+		for _, workerID := range workersID {
+			if _, exist := uniq[workerID]; !exist {
+				uniq[workerID] = struct{}{}
+				depsOn = append(depsOn, workerID)
+			}
+		}
+	}
+
+	for _, dep := range job.Deps {
+		add(c.artifacts[dep])
+	}
+	for fileID := range job.SourceFiles {
+		add(c.files[fileID])
+	}
+
+	return
+}
+
+// wait ждёт timeout и сообщает, нужно ли дальше продвигать джоб по очередям.
+func (c *Scheduler) wait(pending *PendingJob, timeout time.Duration) bool {
+	select {
+	case <-c.timeAfter(timeout):
+		return !pending.isPicked.Load()
+	case <-pending.Finished:
+		return false
+	case <-c.isStop:
+		return false
+	}
+}
+
+// take помечает джоб взятым воркером. Один и тот же джоб может лежать в нескольких очередях,
+// поэтому забрать его может только первый воркер.
+func (c *Scheduler) take(pending *PendingJob, workerID api.WorkerID) bool {
+	if pending.isCloseFinished.Load() {
+		return false
+	}
+
+	if !pending.isPicked.CompareAndSwap(false, true) {
+		return false
+	}
+
+	c.workersMu.Lock()
+	if w, exist := c.workers[workerID]; exist {
+		w.running[pending.Job().ID] = pending
+	}
+	c.workersMu.Unlock()
+
+	return true
+}
+
 func (c *Scheduler) PickJob(ctx context.Context, workerID api.WorkerID) *PendingJob {
 	c.checkIsStop("call `PickingJob` after stop scheduling")
 	defer c.l.Info("pick job", zap.Any("worker_id", workerID))
 
-	select {
-	case pendingJob := <-c.queue.Pop():
+	q := c.workerQueues(workerID)
+
+	for {
+		pending, ok := c.tryPickLocal(q)
+		if !ok {
+			select {
+			case pending = <-q.cached.Pop():
+			case pending = <-q.deps.Pop():
+			case pending = <-c.queue.Pop():
+			case <-ctx.Done():
+				return nil
+			}
+		}
 
-		return pendingJob
-	case <-ctx.Done():
-		return nil
+		if c.take(pending, workerID) {
+			return pending
+		}
 	}
 }
 
 func (c *Scheduler) TryPickJob(ctx context.Context, workerID api.WorkerID) (pending *PendingJob, ok bool) {
 	c.checkIsStop("call `TryPickingJob` after stop scheduling")
 
-	ok = false
-	pending = nil
+	q := c.workerQueues(workerID)
+
+	for {
+		pending, ok = c.tryPickLocal(q)
+		if !ok {
+			select {
+			case pending = <-c.queue.Pop():
+			case <-ctx.Done():
+				pending, ok = nil, true
+				c.l.Debug("try pick job", zap.Any("worker_id", workerID), zap.Bool("pick", ok))
+				return
+			default:
+				c.l.Debug("try pick job", zap.Any("worker_id", workerID), zap.Bool("pick", false))
+				return nil, false
+			}
+		}
+
+		if c.take(pending, workerID) {
+			c.l.Debug("try pick job", zap.Any("worker_id", workerID), zap.Bool("pick", true))
+			return pending, true
+		}
+	}
+}
 
+// tryPickLocal достаёт джоб из локальных очередей воркера, не блокируясь.
+func (c *Scheduler) tryPickLocal(q *workerQueues) (*PendingJob, bool) {
 	select {
-	case pending = <-c.queue.Pop():
-		ok = true
-	case <-ctx.Done():
-		ok = true
+	case pending := <-q.cached.Pop():
+		return pending, true
 	default:
 	}
 
-	c.l.Debug("try pick job", zap.Any("worker_id", workerID), zap.Bool("pick", ok))
-	return
+	select {
+	case pending := <-q.deps.Pop():
+		return pending, true
+	default:
+	}
+
+	return nil, false
 }
 
 func (c *Scheduler) checkIsStop(msg string) {
@@ -194,4 +506,5 @@ func (c *Scheduler) checkIsStop(msg string) {
 
 func (c *Scheduler) Stop() {
 	close(c.isStop)
+	c.wg.Wait()
 }
@@ -33,7 +33,7 @@ func TestScheduler_BasicFlow(t *testing.T) {
 
 	pickedJob, ok := s.TryPickJob(ctx, workerID)
 	require.True(t, ok)
-	require.Equal(t, jobSpec.ID, pickedJob.Job.ID)
+	require.Equal(t, jobSpec.ID, pickedJob.Job().ID)
 
 	result := &api.JobResult{}
 	wasKnown := s.OnJobComplete(workerID, jobSpec.ID, result)
@@ -164,7 +164,7 @@ func TestScheduler_ConcurrentAccess(t *testing.T) {
 				job := s.PickJob(ctx, workerID)
 				cancel()
 				if job != nil {
-					s.OnJobComplete(workerID, job.Job.ID, &api.JobResult{})
+					s.OnJobComplete(workerID, job.Job().ID, &api.JobResult{})
 				}
 			}
 		}(i)
@@ -182,3 +182,387 @@ func TestScheduler_ConcurrentAccess(t *testing.T) {
 		}
 	}
 }
+
+type fakeTimer struct {
+	fired chan chan time.Time
+}
+
+func newFakeTimer() *fakeTimer {
+	return &fakeTimer{fired: make(chan chan time.Time, 16)}
+}
+
+func (f *fakeTimer) After(time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	f.fired <- ch
+	return ch
+}
+
+func (f *fakeTimer) Fire(t *testing.T) {
+	select {
+	case ch := <-f.fired:
+		ch <- time.Now()
+	case <-time.After(time.Second):
+		t.Fatal("timer was not started")
+	}
+}
+
+func TestScheduler_PreferWorkerWithDeps(t *testing.T) {
+	timer := newFakeTimer()
+	s := NewScheduler(zaptest.NewLogger(t), Config{DepsTimeout: time.Minute}, timer.After)
+	defer s.Stop()
+
+	w1, w2 := api.WorkerID("worker-1"), api.WorkerID("worker-2")
+	s.RegisterWorker(w1)
+	s.RegisterWorker(w2)
+
+	dep := build.ID{'a'}
+	s.OnJobComplete(w1, dep, &api.JobResult{ID: dep})
+
+	job := &api.JobSpec{Job: build.Job{ID: build.ID{'b'}, Deps: []build.ID{dep}}}
+	s.ScheduleJob(job)
+
+	ctx := context.Background()
+
+	_, ok := s.TryPickJob(ctx, w2)
+	require.False(t, ok)
+
+	picked, ok := s.TryPickJob(ctx, w1)
+	require.True(t, ok)
+	require.Equal(t, job, picked.Job())
+
+	timer.Fire(t)
+
+	_, ok = s.TryPickJob(ctx, w2)
+	require.False(t, ok, "picked job must not be pushed to the global queue")
+}
+
+func TestScheduler_PreferWorkerWithSourceFiles(t *testing.T) {
+	timer := newFakeTimer()
+	s := NewScheduler(zaptest.NewLogger(t), Config{DepsTimeout: time.Minute}, timer.After)
+	defer s.Stop()
+
+	w1, w2 := api.WorkerID("worker-1"), api.WorkerID("worker-2")
+	file := build.ID{'f'}
+
+	first := &api.JobSpec{
+		SourceFiles: map[build.ID]string{file: "a.txt"},
+		Job:         build.Job{ID: build.ID{'a'}},
+	}
+	s.ScheduleJob(first)
+	s.OnJobComplete(w1, first.ID, &api.JobResult{ID: first.ID})
+
+	second := &api.JobSpec{
+		SourceFiles: map[build.ID]string{file: "a.txt"},
+		Job:         build.Job{ID: build.ID{'b'}},
+	}
+	s.ScheduleJob(second)
+
+	ctx := context.Background()
+
+	_, ok := s.TryPickJob(ctx, w2)
+	require.False(t, ok)
+
+	picked, ok := s.TryPickJob(ctx, w1)
+	require.True(t, ok)
+	require.Equal(t, second, picked.Job())
+}
+
+func TestScheduler_OnFilesRemoved(t *testing.T) {
+	timer := newFakeTimer()
+	s := NewScheduler(zaptest.NewLogger(t), Config{DepsTimeout: time.Minute}, timer.After)
+	defer s.Stop()
+
+	w1, w2 := api.WorkerID("worker-1"), api.WorkerID("worker-2")
+	file := build.ID{'f'}
+
+	first := &api.JobSpec{
+		SourceFiles: map[build.ID]string{file: "a.txt"},
+		Job:         build.Job{ID: build.ID{'a'}},
+	}
+	s.ScheduleJob(first)
+	s.OnJobComplete(w1, first.ID, &api.JobResult{ID: first.ID})
+
+	// Файл вытеснен из кеша воркера, поэтому воркер больше не предпочтительнее остальных.
+	s.OnFilesRemoved(w1, []build.ID{file})
+
+	second := &api.JobSpec{
+		SourceFiles: map[build.ID]string{file: "a.txt"},
+		Job:         build.Job{ID: build.ID{'b'}},
+	}
+	s.ScheduleJob(second)
+
+	picked, ok := s.TryPickJob(context.Background(), w2)
+	require.True(t, ok)
+	require.Equal(t, second, picked.Job())
+}
+
+func TestScheduler_DepsTimeout(t *testing.T) {
+	timer := newFakeTimer()
+	s := NewScheduler(zaptest.NewLogger(t), Config{DepsTimeout: time.Minute}, timer.After)
+	defer s.Stop()
+
+	w1, w2 := api.WorkerID("worker-1"), api.WorkerID("worker-2")
+
+	dep := build.ID{'a'}
+	s.OnJobComplete(w1, dep, &api.JobResult{ID: dep})
+
+	job := &api.JobSpec{Job: build.Job{ID: build.ID{'b'}, Deps: []build.ID{dep}}}
+	s.ScheduleJob(job)
+
+	_, ok := s.TryPickJob(context.Background(), w2)
+	require.False(t, ok)
+
+	timer.Fire(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	picked := s.PickJob(ctx, w2)
+	require.NotNil(t, picked)
+	require.Equal(t, job, picked.Job())
+
+	_, ok = s.TryPickJob(context.Background(), w1)
+	require.False(t, ok, "job must be picked only once")
+}
+
+func TestScheduler_CacheTimeout(t *testing.T) {
+	timer := newFakeTimer()
+	s := NewScheduler(zaptest.NewLogger(t), Config{CacheTimeout: time.Minute, DepsTimeout: time.Minute}, timer.After)
+	defer s.Stop()
+
+	w1, w2, w3 := api.WorkerID("worker-1"), api.WorkerID("worker-2"), api.WorkerID("worker-3")
+
+	dep := build.ID{'a'}
+	s.OnJobComplete(w2, dep, &api.JobResult{ID: dep})
+
+	// Артефакт джоба уже есть на w1, но сам джоб шедулер ещё не видел.
+	job := &api.JobSpec{Job: build.Job{ID: build.ID{'b'}, Deps: []build.ID{dep}}}
+	s.artifacts[job.ID] = []api.WorkerID{w1}
+	s.ScheduleJob(job)
+
+	ctx := context.Background()
+
+	_, ok := s.TryPickJob(ctx, w2)
+	require.False(t, ok)
+	_, ok = s.TryPickJob(ctx, w3)
+	require.False(t, ok)
+
+	timer.Fire(t)
+
+	require.Eventually(t, func() bool {
+		_, ok := s.TryPickJob(ctx, w3)
+		require.False(t, ok)
+
+		picked, ok := s.TryPickJob(ctx, w2)
+		return ok && picked.Job() == job
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (f *fakeTimer) FireAll() {
+	for {
+		select {
+		case ch := <-f.fired:
+			ch <- time.Now()
+		default:
+			return
+		}
+	}
+}
+
+func TestScheduler_WorkerTimeout(t *testing.T) {
+	timer := newFakeTimer()
+	s := NewScheduler(zaptest.NewLogger(t), Config{WorkerTimeout: time.Minute}, timer.After)
+	defer s.Stop()
+
+	w1, w2 := api.WorkerID("worker-1"), api.WorkerID("worker-2")
+
+	done := s.RegisterWorker(w1)
+
+	dep := build.ID{'a'}
+	s.OnJobComplete(w1, dep, &api.JobResult{ID: dep})
+
+	job := &api.JobSpec{Job: build.Job{ID: build.ID{'b'}}}
+	s.ScheduleJob(job)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	picked := s.PickJob(ctx, w1)
+	require.NotNil(t, picked)
+
+	// Пока heartbeat обрабатывается, воркер жив.
+	timer.FireAll()
+	require.Equal(t, []WorkerState{{ID: w1, Alive: true, LastSeen: s.WorkerStates()[0].LastSeen, Jobs: []build.ID{job.ID}}}, s.WorkerStates())
+
+	done()
+
+	require.Eventually(t, func() bool {
+		timer.FireAll()
+		return !s.WorkerStates()[0].Alive
+	}, time.Second, 10*time.Millisecond)
+
+	require.Empty(t, s.WorkerStates()[0].Jobs)
+
+	_, ok := s.LocateArtifact(dep)
+	require.False(t, ok)
+
+	defer s.RegisterWorker(w2)()
+
+	picked = s.PickJob(ctx, w2)
+	require.NotNil(t, picked)
+	require.Equal(t, job.ID, picked.Job().ID)
+
+	s.OnJobComplete(w2, job.ID, &api.JobResult{ID: job.ID})
+	require.Empty(t, s.WorkerStates()[1].Jobs)
+}
+
+func TestScheduler_CancelJob(t *testing.T) {
+	s := NewScheduler(zaptest.NewLogger(t), Config{}, time.After)
+	defer s.Stop()
+
+	workerID := api.WorkerID("worker-1")
+	defer s.RegisterWorker(workerID)()
+
+	job := &api.JobSpec{Job: build.Job{ID: build.ID{'a'}}}
+
+	pending := s.ScheduleJob(job)
+	require.Equal(t, pending, s.ScheduleJob(job))
+
+	// Джоб ждут два билда, поэтому отмена одного из них джоб не отменяет.
+	require.False(t, s.CancelJob(job.ID))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.Equal(t, pending, s.PickJob(ctx, workerID))
+
+	require.True(t, s.CancelJob(job.ID))
+	<-pending.Finished
+	require.NotNil(t, pending.Result.Error)
+
+	require.Equal(t, []build.ID{job.ID}, s.CancelledJobs(workerID))
+	require.Empty(t, s.CancelledJobs(workerID))
+	require.Empty(t, s.WorkerStates()[0].Jobs)
+
+	// Отменённый джоб можно запланировать заново.
+	rescheduled := s.ScheduleJob(job)
+	require.NotEqual(t, pending, rescheduled)
+	require.Equal(t, rescheduled, s.PickJob(ctx, workerID))
+}
+
+func TestScheduler_CancelQueuedJob(t *testing.T) {
+	s := NewScheduler(zaptest.NewLogger(t), Config{}, time.After)
+	defer s.Stop()
+
+	job := &api.JobSpec{Job: build.Job{ID: build.ID{'a'}}}
+	s.ScheduleJob(job)
+
+	require.True(t, s.CancelJob(job.ID))
+
+	_, ok := s.TryPickJob(context.Background(), api.WorkerID("worker-1"))
+	require.False(t, ok, "cancelled job must not be picked")
+}
+
+func TestScheduler_FailedJob(t *testing.T) {
+	s := NewScheduler(zaptest.NewLogger(t), Config{}, time.After)
+	defer s.Stop()
+
+	workerID := api.WorkerID("worker-1")
+	job := &api.JobSpec{Job: build.Job{ID: build.ID{'a'}}}
+
+	pending := s.ScheduleJob(job)
+
+	errMsg := "exit status 1"
+	require.True(t, s.OnJobComplete(workerID, job.ID, &api.JobResult{ID: job.ID, Error: &errMsg}))
+	<-pending.Finished
+
+	_, ok := s.LocateArtifact(job.ID)
+	require.False(t, ok, "failed job must not leave an artifact")
+
+	// Следующий билд запускает упавший джоб заново.
+	require.NotEqual(t, pending, s.ScheduleJob(job))
+}
+
+func TestScheduler_SyncArtifacts(t *testing.T) {
+	s := NewScheduler(zaptest.NewLogger(t), Config{CacheTimeout: time.Minute}, time.After)
+	defer s.Stop()
+
+	workerID := api.WorkerID("worker-1")
+	stale, cached := build.ID{'s'}, build.ID{'c'}
+
+	s.OnJobComplete(workerID, stale, &api.JobResult{ID: stale})
+
+	_, known := s.ArtifactsDigest(workerID)
+	require.False(t, known)
+	require.False(t, s.HasArtifacts(workerID))
+
+	s.SyncArtifacts(workerID, []build.ID{cached})
+
+	// Опись обновляет только расположение артефактов, джобы появляются в шедулере лишь через ScheduleJob.
+	s.jobsMu.Lock()
+	require.NotContains(t, s.jobs, cached)
+	s.jobsMu.Unlock()
+
+	digest, known := s.ArtifactsDigest(workerID)
+	require.True(t, known)
+	require.True(t, s.HasArtifacts(workerID))
+	require.Equal(t, api.InventoryDigest([]build.ID{cached}), digest)
+
+	location, ok := s.LocateArtifact(cached)
+	require.True(t, ok)
+	require.Equal(t, workerID, location)
+
+	_, ok = s.LocateArtifact(stale)
+	require.False(t, ok, "artifact missing from the inventory must be forgotten")
+
+	// Джоб из кеша достаётся воркеру, который хранит его артефакт.
+	pending := s.ScheduleJob(&api.JobSpec{Job: build.Job{ID: cached}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, ok = s.TryPickJob(ctx, api.WorkerID("worker-2"))
+	require.False(t, ok)
+
+	picked, ok := s.TryPickJob(ctx, workerID)
+	require.True(t, ok)
+	require.Equal(t, pending, picked)
+
+	select {
+	case <-pending.Finished:
+		t.Fatal("job must be finished by the worker, not by the inventory")
+	default:
+	}
+
+	// Новые артефакты воркера попадают в его опись.
+	s.OnJobComplete(workerID, stale, &api.JobResult{ID: stale})
+
+	digest, _ = s.ArtifactsDigest(workerID)
+	require.Equal(t, api.InventoryDigest([]build.ID{cached, stale}), digest)
+}
+
+func TestScheduler_OnArtifactsRemoved(t *testing.T) {
+	s := NewScheduler(zaptest.NewLogger(t), Config{}, time.After)
+	defer s.Stop()
+
+	worker1, worker2 := api.WorkerID("worker-1"), api.WorkerID("worker-2")
+	jobA, jobB := build.ID{'a'}, build.ID{'b'}
+
+	s.SyncArtifacts(worker1, []build.ID{jobA, jobB})
+	s.SyncArtifacts(worker2, []build.ID{jobA})
+
+	s.OnArtifactsRemoved(worker1, []build.ID{jobA})
+
+	location, ok := s.LocateArtifact(jobA)
+	require.True(t, ok)
+	require.Equal(t, worker2, location)
+
+	s.OnArtifactsRemoved(worker2, []build.ID{jobA})
+
+	_, ok = s.LocateArtifact(jobA)
+	require.False(t, ok)
+
+	digest, known := s.ArtifactsDigest(worker1)
+	require.True(t, known)
+	require.Equal(t, api.InventoryDigest([]build.ID{jobB}), digest)
+}
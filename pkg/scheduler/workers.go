@@ -0,0 +1,225 @@
+package scheduler
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"gitlab.com/justnurik/distbuild/pkg/api"
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// RegisterWorker отмечает начало heartbeat-а воркера. Возвращённую функцию нужно вызвать,
+// когда обработка heartbeat-а завершится.
+//
+// Таймаут воркера отсчитывается от конца последнего heartbeat-а. Если воркер считался мёртвым,
+// он снова становится живым.
+func (c *Scheduler) RegisterWorker(workerID api.WorkerID) (done func()) {
+	c.checkIsStop("call `RegisterWorker` after stop scheduling")
+
+	c.workersMu.Lock()
+	defer c.workersMu.Unlock()
+
+	w := c.worker(workerID)
+	w.heartbeats++
+	w.lastSeen = time.Now()
+
+	if !w.alive {
+		w.alive = true
+		c.l.Info("worker is alive", zap.String("worker_id", workerID.String()))
+
+		if c.config.WorkerTimeout > 0 {
+			c.wg.Add(1)
+			go c.watchWorker(workerID, w.touch)
+		}
+	}
+
+	var once bool
+	return func() {
+		c.workersMu.Lock()
+		defer c.workersMu.Unlock()
+
+		if once {
+			return
+		}
+		once = true
+
+		w.heartbeats--
+		w.lastSeen = time.Now()
+
+		select {
+		case w.touch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// CancelledJobs возвращает джобы, которые воркер исполнял и которые с тех пор были отменены.
+// Каждый джоб возвращается один раз.
+func (c *Scheduler) CancelledJobs(workerID api.WorkerID) []build.ID {
+	c.workersMu.Lock()
+	defer c.workersMu.Unlock()
+
+	w, exist := c.workers[workerID]
+	if !exist {
+		return nil
+	}
+
+	cancelled := w.cancelled
+	w.cancelled = nil
+	return cancelled
+}
+
+// WorkerStates возвращает состояние всех известных шедулеру воркеров.
+func (c *Scheduler) WorkerStates() []WorkerState {
+	c.workersMu.Lock()
+	defer c.workersMu.Unlock()
+
+	states := make([]WorkerState, 0, len(c.workers))
+	for workerID, w := range c.workers {
+		state := WorkerState{
+			ID:       workerID,
+			Alive:    w.alive,
+			LastSeen: w.lastSeen,
+			Jobs:     make([]build.ID, 0, len(w.running)),
+		}
+
+		for jobID, pending := range w.running {
+			if !pending.isCloseFinished.Load() {
+				state.Jobs = append(state.Jobs, jobID)
+			}
+		}
+
+		states = append(states, state)
+	}
+
+	slices.SortFunc(states, func(a, b WorkerState) int {
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+
+	return states
+}
+
+func (c *Scheduler) workerQueues(workerID api.WorkerID) *workerQueues {
+	c.workersMu.Lock()
+	defer c.workersMu.Unlock()
+
+	return c.worker(workerID).workerQueues
+}
+
+// worker возвращает состояние воркера, создавая его при необходимости. Вызывается под workersMu.
+func (c *Scheduler) worker(workerID api.WorkerID) *workerInfo {
+	w, exist := c.workers[workerID]
+	if !exist {
+		w = &workerInfo{
+			workerQueues: &workerQueues{
+				cached: newChanQueue[*PendingJob](),
+				deps:   newChanQueue[*PendingJob](),
+			},
+			running: make(map[build.ID]*PendingJob),
+			touch:   make(chan struct{}, 1),
+		}
+		c.workers[workerID] = w
+	}
+
+	return w
+}
+
+// watchWorker ждёт, пока воркер промолчит дольше WorkerTimeout, и объявляет его мёртвым.
+func (c *Scheduler) watchWorker(workerID api.WorkerID, touch <-chan struct{}) {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-touch:
+		case <-c.timeAfter(c.config.WorkerTimeout):
+			if c.expireWorker(workerID) {
+				return
+			}
+		case <-c.isStop:
+			return
+		}
+	}
+}
+
+// expireWorker объявляет воркер мёртвым, если он не обрабатывает heartbeat прямо сейчас.
+//
+// Артефакты и исходные файлы мёртвого воркера забываются, а взятые им джобы снова попадают в очереди.
+func (c *Scheduler) expireWorker(workerID api.WorkerID) bool {
+	c.workersMu.Lock()
+
+	w := c.workers[workerID]
+	if w.heartbeats > 0 {
+		c.workersMu.Unlock()
+		return false
+	}
+
+	w.alive = false
+	running := w.running
+	w.running = make(map[build.ID]*PendingJob)
+
+	c.workersMu.Unlock()
+
+	c.l.Warn("worker is dead",
+		zap.String("worker_id", workerID.String()),
+		zap.Int("running_jobs", len(running)))
+
+	c.artifactsMu.Lock()
+	removeLocations(c.artifacts, workerID)
+	removeLocations(c.files, workerID)
+	delete(c.inventories, workerID)
+	c.artifactsMu.Unlock()
+
+	for _, pending := range running {
+		c.requeue(pending, workerID)
+	}
+
+	return true
+}
+
+// requeue возвращает в очереди джоб, который взял мёртвый воркер.
+func (c *Scheduler) requeue(pending *PendingJob, deadWorkerID api.WorkerID) {
+	if pending.isCloseFinished.Load() {
+		return
+	}
+
+	// Артефакты зависимостей могли остаться только на мёртвом воркере.
+	spec := *pending.Job()
+	spec.Artifacts = make(map[build.ID]api.WorkerID, len(spec.Artifacts))
+	for depID, workerID := range pending.Job().Artifacts {
+		if workerID == deadWorkerID {
+			if location, exist := c.locateArtifact(depID); exist {
+				workerID = location
+			}
+		}
+		spec.Artifacts[depID] = workerID
+	}
+	pending.job.Store(&spec)
+
+	c.l.Info("requeue job",
+		zap.String("job_id", spec.ID.String()),
+		zap.String("dead_worker_id", deadWorkerID.String()))
+
+	pending.isPicked.Store(false)
+	c.enqueue(pending)
+}
+
+func removeLocations(locations map[build.ID]workerCache, workerID api.WorkerID) {
+	for id := range locations {
+		removeLocation(locations, id, workerID)
+	}
+}
+
+func removeLocation(locations map[build.ID]workerCache, id build.ID, workerID api.WorkerID) {
+	workersID := slices.DeleteFunc(locations[id], func(w api.WorkerID) bool {
+		return w == workerID
+	})
+
+	if len(workersID) == 0 {
+		delete(locations, id)
+	} else {
+		locations[id] = workersID
+	}
+}
@@ -0,0 +1,39 @@
+package tarstream
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+)
+
+// Digest - sha256 от байтов потока. Send без WithModTimes даёт одинаковый поток для директорий с одинаковыми
+// содержимым, правами и жёсткими ссылками, поэтому Digest идентифицирует директорию.
+type Digest [sha256.Size]byte
+
+var (
+	_ = encoding.TextMarshaler(Digest{})
+	_ = encoding.TextUnmarshaler(&Digest{})
+)
+
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+func (d Digest) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(d[:])), nil
+}
+
+func (d *Digest) UnmarshalText(b []byte) error {
+	raw, err := hex.DecodeString(string(b))
+	if err != nil {
+		return err
+	}
+
+	if len(raw) != len(d) {
+		return fmt.Errorf("invalid digest size: %q", b)
+	}
+
+	copy(d[:], raw)
+	return nil
+}
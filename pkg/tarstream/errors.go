@@ -0,0 +1,28 @@
+package tarstream
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnsafePath       = errors.New("path escapes the destination directory")
+	ErrUnsafeLink       = errors.New("link target escapes the destination directory")
+	ErrTooLarge         = errors.New("size limit exceeded")
+	ErrTooManyEntries   = errors.New("entry limit exceeded")
+	ErrUnsupportedEntry = errors.New("unsupported entry type")
+)
+
+// EntryError описывает запись потока, которую Receive отказался распаковать.
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("tarstream: entry %q: %v", e.Name, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package tarstream
+
+import "os"
+
+type fileKey struct{}
+
+// hardLinkKey не распознаёт жёсткие ссылки: на этих платформах каждая ссылка передаётся как отдельный файл.
+func hardLinkKey(os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package tarstream
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileKey идентифицирует файл на диске независимо от пути к нему.
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// hardLinkKey возвращает ключ файла, если на него ведёт несколько жёстких ссылок.
+func hardLinkKey(info os.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileKey{}, false
+	}
+
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
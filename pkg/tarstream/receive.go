@@ -0,0 +1,235 @@
+package tarstream
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Receive читает поток r и материализует содержимое потока внутри dir.
+//
+// Поток считается недоверенным: записи с абсолютными путями или путями, выходящими из dir,
+// ссылки, указывающие за пределы dir, и записи, путь к которым проходит через симлинк, отвергаются
+// с ErrUnsafePath или ErrUnsafeLink. Ограничения WithMaxBytes и WithMaxEntries проверяются до того,
+// как запись попадёт на диск. Ошибки, относящиеся к конкретной записи, имеют тип *EntryError.
+func Receive(dir string, r io.Reader, opts ...Option) error {
+	o := newOptions(opts)
+
+	var digest hash.Hash
+	if o.digest != nil {
+		digest = sha256.New()
+		r = io.TeeReader(r, digest)
+	}
+
+	tr := tar.NewReader(r)
+
+	// Права и время изменения директорий выставляются в конце: директория без права на запись
+	// не даст создать в ней файлы, а каждый новый файл меняет время изменения директории.
+	var dirs []*tar.Header
+
+	var entries int
+	var size int64
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		entries++
+		if o.maxEntries > 0 && entries > o.maxEntries {
+			return &EntryError{Name: h.Name, Err: fmt.Errorf("%w: more than %d entries", ErrTooManyEntries, o.maxEntries)}
+		}
+
+		if err := receiveEntry(dir, tr, h, &o, &size); err != nil {
+			var entryErr *EntryError
+			if !errors.As(err, &entryErr) {
+				err = &EntryError{Name: h.Name, Err: err}
+			}
+			return err
+		}
+
+		if h.Typeflag == tar.TypeDir {
+			dirs = append(dirs, h)
+		}
+	}
+
+	// Вложенные директории идут в потоке после родительских, поэтому обрабатываются первыми.
+	for _, h := range slices.Backward(dirs) {
+		absPath := filepath.Join(dir, filepath.FromSlash(h.Name))
+
+		if err := os.Chmod(absPath, os.FileMode(h.Mode).Perm()); err != nil {
+			return err
+		}
+
+		if o.modTimes {
+			if err := os.Chtimes(absPath, time.Time{}, h.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	if digest != nil {
+		// tar.Reader может не дочитать выравнивание после последней записи.
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+		copy(o.digest[:], digest.Sum(nil))
+	}
+
+	return nil
+}
+
+func receiveEntry(dir string, tr *tar.Reader, h *tar.Header, o *options, size *int64) error {
+	name, err := localName(h.Name)
+	if err != nil {
+		return err
+	}
+	h.Name = name
+
+	if err := checkParents(dir, name); err != nil {
+		return err
+	}
+
+	absPath := filepath.Join(dir, filepath.FromSlash(name))
+	mode := os.FileMode(h.Mode).Perm()
+
+	switch h.Typeflag {
+	case tar.TypeDir:
+		return os.Mkdir(absPath, 0777)
+
+	case tar.TypeSymlink:
+		if err := checkSymlink(name, h.Linkname); err != nil {
+			return err
+		}
+
+		// Время изменения самого симлинка не восстанавливается.
+		return os.Symlink(h.Linkname, absPath)
+
+	case tar.TypeLink:
+		target, err := localName(h.Linkname)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrUnsafeLink, h.Linkname)
+		}
+
+		if err := checkParents(dir, target); err != nil {
+			return err
+		}
+
+		// Жёсткая ссылка может вести только на уже распакованный обычный файл.
+		targetPath := filepath.Join(dir, filepath.FromSlash(target))
+		info, err := os.Lstat(targetPath)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%w: %q is not a regular file", ErrUnsafeLink, h.Linkname)
+		}
+
+		return os.Link(targetPath, absPath)
+
+	case tar.TypeReg:
+		if h.Size < 0 || (o.maxBytes > 0 && *size+h.Size > o.maxBytes) {
+			return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, o.maxBytes)
+		}
+		*size += h.Size
+
+		if err := writeFile(absPath, mode, tr); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("%w %q", ErrUnsupportedEntry, h.Typeflag)
+	}
+
+	if err := os.Chmod(absPath, mode); err != nil {
+		return err
+	}
+
+	if o.modTimes {
+		return os.Chtimes(absPath, time.Time{}, h.ModTime)
+	}
+
+	return nil
+}
+
+// localName проверяет, что имя записи - относительный путь внутри директории, и возвращает его
+// в каноническом виде.
+func localName(name string) (string, error) {
+	clean := path.Clean(strings.TrimSuffix(name, "/"))
+	if name == "" || clean == "." || strings.Contains(name, "\\") || !filepath.IsLocal(filepath.FromSlash(clean)) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+
+	return clean, nil
+}
+
+// checkSymlink проверяет, что симлинк name указывает внутрь директории.
+//
+// ".." допускается только в начале ссылки. Спустившись в поддиректорию, ".." может подняться через
+// другой симлинк, и лексическая проверка перестанет соответствовать пути, который увидит ядро.
+func checkSymlink(name, target string) error {
+	if target == "" || path.IsAbs(target) || strings.Contains(target, "\\") {
+		return fmt.Errorf("%w: %q", ErrUnsafeLink, target)
+	}
+
+	components := strings.Split(target, "/")
+	for len(components) > 0 && components[0] == ".." {
+		components = components[1:]
+	}
+
+	if slices.Contains(components, "..") || !filepath.IsLocal(filepath.FromSlash(path.Join(path.Dir(name), target))) {
+		return fmt.Errorf("%w: %q", ErrUnsafeLink, target)
+	}
+
+	return nil
+}
+
+// checkParents проверяет, что ни одна из родительских директорий name внутри dir не симлинк.
+// Иначе запись через симлинк могла бы попасть за пределы dir.
+func checkParents(dir, name string) error {
+	parent := dir
+	for _, component := range strings.Split(path.Dir(name), "/") {
+		if component == "." {
+			break
+		}
+
+		parent = filepath.Join(parent, component)
+
+		info, err := os.Lstat(parent)
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return fmt.Errorf("%w: %q is not a directory", ErrUnsafePath, component)
+		}
+	}
+
+	return nil
+}
+
+func writeFile(path string, mode os.FileMode, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
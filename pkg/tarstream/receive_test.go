@@ -0,0 +1,223 @@
+package tarstream_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/justnurik/distbuild/pkg/tarstream"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func archive(t testing.TB, entries ...entry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, e := range entries {
+		h := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0755,
+			Size:     int64(len(e.content)),
+		}
+		if e.typeflag != tar.TypeReg {
+			h.Size = 0
+		}
+
+		require.NoError(t, tw.WriteHeader(h))
+		if h.Size != 0 {
+			_, err := tw.Write([]byte(e.content))
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func file(name, content string) entry {
+	return entry{name: name, typeflag: tar.TypeReg, content: content}
+}
+
+func dir(name string) entry {
+	return entry{name: name, typeflag: tar.TypeDir}
+}
+
+func symlink(name, target string) entry {
+	return entry{name: name, typeflag: tar.TypeSymlink, linkname: target}
+}
+
+func hardlink(name, target string) entry {
+	return entry{name: name, typeflag: tar.TypeLink, linkname: target}
+}
+
+func TestReceiveRejectsUnsafeEntries(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []entry
+		err     error
+		entry   string
+	}{
+		{"ParentPath", []entry{file("../evil", "x")}, tarstream.ErrUnsafePath, "../evil"},
+		{"NestedParentPath", []entry{dir("a"), file("a/../../evil", "x")}, tarstream.ErrUnsafePath, "a/../../evil"},
+		{"AbsolutePath", []entry{file("/tmp/evil", "x")}, tarstream.ErrUnsafePath, "/tmp/evil"},
+		{"EmptyPath", []entry{file("", "x")}, tarstream.ErrUnsafePath, ""},
+		{"AbsoluteSymlink", []entry{symlink("l", "/etc")}, tarstream.ErrUnsafeLink, "l"},
+		{"EscapingSymlink", []entry{dir("a"), symlink("a/l", "../../x")}, tarstream.ErrUnsafeLink, "a/l"},
+		{"InnerParentSymlink", []entry{dir("a"), symlink("a/s", ".."), symlink("l", "a/s/../x")}, tarstream.ErrUnsafeLink, "l"},
+		{"WriteThroughSymlink", []entry{symlink("l", "."), file("l/f", "x")}, tarstream.ErrUnsafePath, "l/f"},
+		{"EscapingHardlink", []entry{hardlink("l", "../x")}, tarstream.ErrUnsafeLink, "l"},
+		{"HardlinkThroughSymlink", []entry{dir("a"), file("a/f", "x"), symlink("s", "a"), hardlink("l", "s/f")}, tarstream.ErrUnsafePath, "l"},
+		{"HardlinkToSymlink", []entry{symlink("s", "x"), hardlink("l", "s")}, tarstream.ErrUnsafeLink, "l"},
+		{"Fifo", []entry{{name: "p", typeflag: tar.TypeFifo}}, tarstream.ErrUnsupportedEntry, "p"},
+		{"CharDevice", []entry{{name: "c", typeflag: tar.TypeChar}}, tarstream.ErrUnsupportedEntry, "c"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			to := filepath.Join(root, "to")
+			require.NoError(t, os.Mkdir(to, 0777))
+
+			err := tarstream.Receive(to, bytes.NewReader(archive(t, tc.entries...)))
+			require.ErrorIs(t, err, tc.err)
+
+			var entryErr *tarstream.EntryError
+			require.ErrorAs(t, err, &entryErr)
+			require.Equal(t, tc.entry, entryErr.Name)
+
+			checkConfined(t, root, to)
+		})
+	}
+}
+
+func TestReceiveAllowsLocalLinks(t *testing.T) {
+	to := t.TempDir()
+
+	require.NoError(t, tarstream.Receive(to, bytes.NewReader(archive(t,
+		dir("a"),
+		dir("a/b"),
+		file("a/f", "x"),
+		symlink("a/b/up", "../f"),
+		symlink("top", "a/b/up"),
+		hardlink("a/h", "a/f"),
+	))))
+
+	b, err := os.ReadFile(filepath.Join(to, "top"))
+	require.NoError(t, err)
+	require.Equal(t, "x", string(b))
+
+	b, err = os.ReadFile(filepath.Join(to, "a", "h"))
+	require.NoError(t, err)
+	require.Equal(t, "x", string(b))
+}
+
+func TestReceiveLimits(t *testing.T) {
+	data := archive(t, dir("a"), file("a/x", "xxxx"), file("a/y", "yyyy"))
+
+	t.Run("Bytes", func(t *testing.T) {
+		err := tarstream.Receive(t.TempDir(), bytes.NewReader(data), tarstream.WithMaxBytes(6))
+		require.ErrorIs(t, err, tarstream.ErrTooLarge)
+
+		var entryErr *tarstream.EntryError
+		require.ErrorAs(t, err, &entryErr)
+		require.Equal(t, "a/y", entryErr.Name)
+	})
+
+	t.Run("Entries", func(t *testing.T) {
+		err := tarstream.Receive(t.TempDir(), bytes.NewReader(data), tarstream.WithMaxEntries(2))
+		require.ErrorIs(t, err, tarstream.ErrTooManyEntries)
+
+		var entryErr *tarstream.EntryError
+		require.ErrorAs(t, err, &entryErr)
+		require.Equal(t, "a/y", entryErr.Name)
+	})
+
+	t.Run("Tighten", func(t *testing.T) {
+		// Более мягкое ограничение не отменяет более строгое.
+		err := tarstream.Receive(t.TempDir(), bytes.NewReader(data),
+			tarstream.WithMaxBytes(6), tarstream.WithMaxBytes(0), tarstream.WithMaxBytes(100))
+		require.ErrorIs(t, err, tarstream.ErrTooLarge)
+	})
+
+	t.Run("WithinLimits", func(t *testing.T) {
+		require.NoError(t, tarstream.Receive(t.TempDir(), bytes.NewReader(data),
+			tarstream.WithMaxBytes(8), tarstream.WithMaxEntries(3)))
+	})
+}
+
+// checkConfined проверяет, что Receive ничего не создал в root за пределами to,
+// а все симлинки внутри to разрешаются внутрь to.
+func checkConfined(t testing.TB, root, to string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "entries created outside of the destination")
+
+	realTo, err := filepath.EvalSymlinks(to)
+	require.NoError(t, err)
+
+	err = filepath.WalkDir(to, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		resolved, err := filepath.EvalSymlinks(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if resolved != realTo && !strings.HasPrefix(resolved, realTo+string(filepath.Separator)) {
+			t.Errorf("symlink %s resolves to %s outside of the destination", path, resolved)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func FuzzReceive(f *testing.F) {
+	f.Add(archive(f, dir("a"), file("a/x", "xxx"), symlink("l", "a/x"), hardlink("h", "a/x")))
+	f.Add(archive(f, file("../evil", "x")))
+	f.Add(archive(f, symlink("l", "/etc"), file("l/passwd", "x")))
+	f.Add(archive(f, dir("a"), symlink("a/s", ".."), symlink("l", "a/s/../x")))
+	f.Add(archive(f, symlink("l", "."), file("l/f", "x")))
+	f.Add(archive(f, dir("a"), symlink("a/l", "../.."), hardlink("h", "a/l/x")))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		root := t.TempDir()
+		to := filepath.Join(root, "to")
+		require.NoError(t, os.Mkdir(to, 0777))
+
+		_ = tarstream.Receive(to, bytes.NewReader(data),
+			tarstream.WithMaxBytes(1<<20), tarstream.WithMaxEntries(64))
+
+		// Receive мог оставить директории без права на запись, их нужно удалить в конце теста.
+		_ = filepath.WalkDir(to, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				_ = os.Chmod(path, 0777)
+			}
+			return nil
+		})
+
+		checkConfined(t, root, to)
+	})
+}
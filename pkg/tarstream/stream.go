@@ -2,15 +2,93 @@ package tarstream
 
 import (
 	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 )
 
+// Option настраивает Send и Receive.
+type Option func(*options)
+
+type options struct {
+	modTimes bool
+
+	digest *Digest
+
+	maxBytes   int64
+	maxEntries int
+}
+
+// WithModTimes переносит время изменения файлов и директорий: Send записывает его в поток,
+// а Receive восстанавливает. Без этой опции Send записывает нулевое время, а Receive его игнорирует.
+func WithModTimes() Option {
+	return func(o *options) {
+		o.modTimes = true
+	}
+}
+
+// WithDigest считает sha256 от байтов потока, который Send записал или Receive прочитал,
+// и сохраняет его в d после успешного завершения.
+func WithDigest(d *Digest) Option {
+	return func(o *options) {
+		o.digest = d
+	}
+}
+
+// WithMaxBytes ограничивает суммарный размер файлов, которые распакует Receive. Ноль не ограничивает.
+// Из нескольких ограничений действует самое строгое.
+func WithMaxBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBytes = tighten(o.maxBytes, n)
+	}
+}
+
+// WithMaxEntries ограничивает число записей потока, которые распакует Receive. Ноль не ограничивает.
+// Из нескольких ограничений действует самое строгое.
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = tighten(o.maxEntries, n)
+	}
+}
+
+// tighten возвращает более строгое из двух ограничений, ноль означает отсутствие ограничения.
+func tighten[T int | int64](limit, n T) T {
+	if limit == 0 || (n != 0 && n < limit) {
+		return n
+	}
+	return limit
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 // Send рекурсивно обходит директорию и сериализует её содержимое в поток w.
-func Send(dir string, w io.Writer) error {
+// Записи внутри каждой директории идут в лексикографическом порядке.
+//
+// Симлинки передаются как ссылки, не разыменовываясь. Повторные жёсткие ссылки на один файл
+// передаются как ссылки на первое вхождение. Права доступа сохраняются.
+func Send(dir string, w io.Writer, opts ...Option) error {
+	o := newOptions(opts)
+
+	var digest hash.Hash
+	if o.digest != nil {
+		digest = sha256.New()
+		w = io.MultiWriter(w, digest)
+	}
+
 	tw := tar.NewWriter(w)
 
+	// links запоминает первое вхождение файлов, у которых есть несколько жёстких ссылок.
+	links := make(map[fileKey]string)
+
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -25,20 +103,38 @@ func Send(dir string, w io.Writer) error {
 			return nil
 		}
 
+		h := &tar.Header{
+			Name: filepath.ToSlash(rel),
+			Mode: int64(info.Mode().Perm()),
+		}
+		if o.modTimes {
+			h.ModTime = info.ModTime()
+		}
+
 		switch {
 		case info.IsDir():
-			return tw.WriteHeader(&tar.Header{
-				Name:     rel,
-				Typeflag: tar.TypeDir,
-			})
+			h.Typeflag = tar.TypeDir
+			return tw.WriteHeader(h)
 
-		default:
-			h := &tar.Header{
-				Typeflag: tar.TypeReg,
-				Name:     rel,
-				Size:     info.Size(),
-				Mode:     int64(info.Mode()),
+		case info.Mode()&os.ModeSymlink != 0:
+			h.Typeflag = tar.TypeSymlink
+			if h.Linkname, err = os.Readlink(path); err != nil {
+				return err
 			}
+			return tw.WriteHeader(h)
+
+		case info.Mode().IsRegular():
+			if key, ok := hardLinkKey(info); ok {
+				if target, seen := links[key]; seen {
+					h.Typeflag = tar.TypeLink
+					h.Linkname = target
+					return tw.WriteHeader(h)
+				}
+				links[key] = h.Name
+			}
+
+			h.Typeflag = tar.TypeReg
+			h.Size = info.Size()
 
 			if err := tw.WriteHeader(h); err != nil {
 				return err
@@ -52,6 +148,9 @@ func Send(dir string, w io.Writer) error {
 
 			_, err = io.Copy(tw, f)
 			return err
+
+		default:
+			return fmt.Errorf("%s: unsupported file type %s", rel, info.Mode().Type())
 		}
 	})
 
@@ -59,42 +158,13 @@ func Send(dir string, w io.Writer) error {
 		return err
 	}
 
-	return tw.Close()
-}
-
-// Receive читает поток r и материализует содержимое потока внутри dir.
-func Receive(dir string, r io.Reader) error {
-	tr := tar.NewReader(r)
-
-	for {
-		h, err := tr.Next()
-		if err == io.EOF {
-			return nil
-		} else if err != nil {
-			return err
-		}
-
-		absPath := filepath.Join(dir, h.Name)
-
-		if h.Typeflag == tar.TypeDir {
-			if err := os.Mkdir(absPath, 0777); err != nil {
-				return err
-			}
-		} else {
-			writeFile := func() error {
-				f, err := os.OpenFile(absPath, os.O_CREATE|os.O_WRONLY, os.FileMode(h.Mode))
-				if err != nil {
-					return err
-				}
-				defer f.Close()
-
-				_, err = io.Copy(f, tr)
-				return err
-			}
+	if err := tw.Close(); err != nil {
+		return err
+	}
 
-			if err := writeFile(); err != nil {
-				return err
-			}
-		}
+	if digest != nil {
+		copy(o.digest[:], digest.Sum(nil))
 	}
+
+	return nil
 }
@@ -5,11 +5,12 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sys/unix"
 
-	"gitlab.com/slon/shad-go/distbuild/pkg/tarstream"
+	"gitlab.com/justnurik/distbuild/pkg/tarstream"
 )
 
 func TestTarStream(t *testing.T) {
@@ -61,6 +62,170 @@ func TestTarStream(t *testing.T) {
 	checkFile(filepath.Join(to, "b", "c", "y.txt"), []byte("yyy"), 0644)
 }
 
+// roundTrip передаёт директорию from через tarstream и возвращает директорию, в которую она распакована.
+func roundTrip(t *testing.T, from string, opts ...tarstream.Option) string {
+	t.Helper()
+
+	to := filepath.Join(t.TempDir(), "to")
+	require.NoError(t, os.Mkdir(to, 0777))
+
+	var buf bytes.Buffer
+	require.NoError(t, tarstream.Send(from, &buf, opts...))
+	require.NoError(t, tarstream.Receive(to, &buf, opts...))
+
+	return to
+}
+
+func TestTarStreamSymlinks(t *testing.T) {
+	from := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(from, "lib"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(from, "lib", "libfoo.so.1"), []byte("elf"), 0755))
+	require.NoError(t, os.Symlink("libfoo.so.1", filepath.Join(from, "lib", "libfoo.so")))
+	require.NoError(t, os.Symlink("lib", filepath.Join(from, "lib64")))
+	require.NoError(t, os.Symlink("missing", filepath.Join(from, "dangling")))
+
+	to := roundTrip(t, from)
+
+	for path, target := range map[string]string{
+		"lib/libfoo.so": "libfoo.so.1",
+		"lib64":         "lib",
+		"dangling":      "missing",
+	} {
+		link, err := os.Readlink(filepath.Join(to, path))
+		require.NoError(t, err, path)
+		require.Equal(t, target, link, path)
+	}
+
+	content, err := os.ReadFile(filepath.Join(to, "lib64", "libfoo.so"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("elf"), content)
+}
+
+func TestTarStreamHardlinks(t *testing.T) {
+	from := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(from, "a"), []byte("aaa"), 0644))
+	require.NoError(t, os.Link(filepath.Join(from, "a"), filepath.Join(from, "b")))
+
+	to := roundTrip(t, from)
+
+	a, err := os.Stat(filepath.Join(to, "a"))
+	require.NoError(t, err)
+	b, err := os.Stat(filepath.Join(to, "b"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(a, b))
+
+	content, err := os.ReadFile(filepath.Join(to, "b"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("aaa"), content)
+}
+
+func TestTarStreamPermissions(t *testing.T) {
+	from := t.TempDir()
+
+	require.NoError(t, os.Mkdir(filepath.Join(from, "ro"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(from, "ro", "tool"), []byte("#!/bin/sh"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(from, "ro", "data"), []byte("data"), 0400))
+	require.NoError(t, os.Chmod(filepath.Join(from, "ro"), 0500))
+	t.Cleanup(func() { _ = os.Chmod(filepath.Join(from, "ro"), 0700) })
+
+	to := roundTrip(t, from)
+	t.Cleanup(func() { _ = os.Chmod(filepath.Join(to, "ro"), 0700) })
+
+	for path, mode := range map[string]os.FileMode{
+		"ro":      os.ModeDir | 0500,
+		"ro/tool": 0700,
+		"ro/data": 0400,
+	} {
+		st, err := os.Stat(filepath.Join(to, path))
+		require.NoError(t, err, path)
+		require.Equal(t, mode.String(), st.Mode().String(), path)
+	}
+}
+
+func TestTarStreamModTimes(t *testing.T) {
+	from := t.TempDir()
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	require.NoError(t, os.Mkdir(filepath.Join(from, "dir"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(from, "dir", "file"), []byte("x"), 0644))
+	for _, path := range []string{"dir/file", "dir"} {
+		require.NoError(t, os.Chtimes(filepath.Join(from, path), mtime, mtime))
+	}
+
+	to := roundTrip(t, from, tarstream.WithModTimes())
+	for _, path := range []string{"dir/file", "dir"} {
+		st, err := os.Stat(filepath.Join(to, path))
+		require.NoError(t, err, path)
+		require.True(t, mtime.Equal(st.ModTime()), "%s: %s", path, st.ModTime())
+	}
+
+	// Без опции время изменения не переносится.
+	to = roundTrip(t, from)
+	st, err := os.Stat(filepath.Join(to, "dir", "file"))
+	require.NoError(t, err)
+	require.False(t, mtime.Equal(st.ModTime()))
+}
+
 func init() {
 	unix.Umask(0022)
 }
+
+func TestTarStreamReproducible(t *testing.T) {
+	send := func(from string) ([]byte, tarstream.Digest) {
+		var buf bytes.Buffer
+		var digest tarstream.Digest
+		require.NoError(t, tarstream.Send(from, &buf, tarstream.WithDigest(&digest)))
+		return buf.Bytes(), digest
+	}
+
+	a := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(a, "bin"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(a, "bin", "tool"), []byte("#!/bin/sh"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(a, "data"), []byte("data"), 0644))
+	require.NoError(t, os.Link(filepath.Join(a, "data"), filepath.Join(a, "copy")))
+	require.NoError(t, os.Symlink("data", filepath.Join(a, "link")))
+
+	// Та же директория, созданная в другом порядке и в другое время.
+	b := t.TempDir()
+	require.NoError(t, os.Symlink("data", filepath.Join(b, "link")))
+	require.NoError(t, os.WriteFile(filepath.Join(b, "data"), []byte("data"), 0644))
+	require.NoError(t, os.Link(filepath.Join(b, "data"), filepath.Join(b, "copy")))
+	require.NoError(t, os.Mkdir(filepath.Join(b, "bin"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(b, "bin", "tool"), []byte("#!/bin/sh"), 0755))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(b, "data"), mtime, mtime))
+
+	streamA, digestA := send(a)
+	streamB, digestB := send(b)
+	require.Equal(t, streamA, streamB)
+	require.Equal(t, digestA, digestB)
+
+	// Дайджест, посчитанный при приёме, совпадает с дайджестом отправителя.
+	var received tarstream.Digest
+	to := t.TempDir()
+	require.NoError(t, tarstream.Receive(to, bytes.NewReader(streamA), tarstream.WithDigest(&received)))
+	require.Equal(t, digestA, received)
+
+	// Права и жёсткие ссылки входят в поток, поэтому меняют дайджест.
+	require.NoError(t, os.Chmod(filepath.Join(b, "bin"), 0755))
+	_, digestB = send(b)
+	require.NotEqual(t, digestA, digestB)
+	require.NoError(t, os.Chmod(filepath.Join(b, "bin"), 0700))
+
+	require.NoError(t, os.Remove(filepath.Join(b, "copy")))
+	require.NoError(t, os.WriteFile(filepath.Join(b, "copy"), []byte("data"), 0644))
+	_, digestB = send(b)
+	require.NotEqual(t, digestA, digestB)
+
+	// Изменение содержимого меняет дайджест.
+	require.NoError(t, os.Remove(filepath.Join(b, "copy")))
+	require.NoError(t, os.Link(filepath.Join(b, "data"), filepath.Join(b, "copy")))
+	_, digestB = send(b)
+	require.Equal(t, digestA, digestB)
+	require.NoError(t, os.WriteFile(filepath.Join(b, "bin", "tool"), []byte("#!/bin/bash"), 0755))
+	_, digestB = send(b)
+	require.NotEqual(t, digestA, digestB)
+}
@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff считает паузы между повторными попытками: пауза растёт экспоненциально от min до max,
+// а случайный разброс не даёт воркерам ломиться к поднявшемуся координатору одновременно.
+type backoff struct {
+	min, max time.Duration
+
+	current time.Duration
+}
+
+// defaultBackoffMin используется, если минимальная пауза не задана.
+const defaultBackoffMin = 100 * time.Millisecond
+
+func newBackoff(min, max time.Duration) *backoff {
+	if min <= 0 {
+		min = defaultBackoffMin
+	}
+	if max < min {
+		max = min
+	}
+	return &backoff{min: min, max: max}
+}
+
+// next возвращает паузу перед следующей попыткой.
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current = min(2*b.current, b.max)
+	}
+
+	// Пауза выбирается случайно из [current/2, current].
+	half := b.current / 2
+	return half + rand.N(b.current-half+1)
+}
+
+// reset сбрасывает паузу после успешной попытки.
+func (b *backoff) reset() {
+	b.current = 0
+}
@@ -0,0 +1,12 @@
+package worker
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile делает dst copy-on-write копией src (reflink) и сообщает, получилось ли.
+func cloneFile(dst, src *os.File) bool {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())) == nil
+}
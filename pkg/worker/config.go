@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"gitlab.com/justnurik/distbuild/pkg/tarstream"
+)
+
+// Config задаёт параметры воркера.
+type Config struct {
+	// RootDir - корневая директория воркера. Внутри неё, в поддиректории jobs,
+	// каждый джоб получает собственную директорию с исходными файлами.
+	RootDir string
+
+	// Slots - сколько джобов воркер исполняет одновременно. По умолчанию runtime.NumCPU().
+	Slots int
+
+	// ReconnectMinDelay и ReconnectMaxDelay ограничивают паузу между повторными heartbeat-ами,
+	// если координатор недоступен. Пауза растёт экспоненциально.
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+
+	// InventoryInterval задаёт, как часто воркер сверяет с координатором содержимое кеша артефактов.
+	InventoryInterval time.Duration
+
+	// MaxArtifactBytes и MaxArtifactEntries ограничивают суммарный размер файлов и число записей
+	// артефакта, скачиваемого с другого воркера. Ноль снимает ограничение.
+	MaxArtifactBytes   int64
+	MaxArtifactEntries int
+
+	// MetricsFile, если задан, - файл, в который воркер раз в 100мс рисует число исполняющихся джобов.
+	MetricsFile string
+
+	// KeepFailedJobDirs оставляет директории упавших джобов на диске для отладки.
+	KeepFailedJobDirs bool
+
+	// Sandbox включает исполнение exec-команд в песочнице (только Linux). Команде видны
+	// директория с исходными файлами, выходная директория, директории зависимостей и
+	// SandboxReadOnlyPaths, сеть недоступна.
+	Sandbox bool
+
+	// SandboxReadOnlyPaths - пути хоста (например, тулчейн), которые видны в песочнице только на чтение.
+	SandboxReadOnlyPaths []string
+}
+
+// DefaultConfig возвращает параметры воркера по умолчанию.
+func DefaultConfig() Config {
+	return Config{
+		RootDir: filepath.Join(os.TempDir(), "distbuild-worker"),
+
+		ReconnectMinDelay: defaultBackoffMin,
+		ReconnectMaxDelay: 5 * time.Second,
+
+		InventoryInterval: defaultInventoryInterval,
+
+		SandboxReadOnlyPaths: []string{"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/etc"},
+	}
+}
+
+func (c Config) slots() int {
+	if c.Slots > 0 {
+		return c.Slots
+	}
+	return runtime.NumCPU()
+}
+
+const defaultInventoryInterval = time.Minute
+
+func (c Config) inventoryInterval() time.Duration {
+	if c.InventoryInterval > 0 {
+		return c.InventoryInterval
+	}
+	return defaultInventoryInterval
+}
+
+func (c Config) downloadOptions() []tarstream.Option {
+	return []tarstream.Option{
+		tarstream.WithMaxBytes(c.MaxArtifactBytes),
+		tarstream.WithMaxEntries(c.MaxArtifactEntries),
+	}
+}
+
+func (c Config) jobsDir() string {
+	return filepath.Join(c.RootDir, "jobs")
+}
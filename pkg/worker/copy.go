@@ -1,6 +1,9 @@
 package worker
 
 import (
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -8,7 +11,12 @@ import (
 	"gitlab.com/justnurik/distbuild/pkg/filecache"
 )
 
-func linkFiles(from *filecache.Cache, toDir string, fileID build.ID, filePath string) error {
+// Файлы из кешей нельзя отдавать джобу напрямую (например, хардлинком): команда, которая пишет во вход,
+// испортила бы запись кеша для всех следующих джобов. Поэтому входы копируются в директорию джоба.
+// Где файловая система это умеет, копия делается через reflink и почти ничего не стоит.
+
+// copySourceFile копирует файл fileID из кеша в toDir/filePath.
+func copySourceFile(from *filecache.Cache, toDir string, fileID build.ID, filePath string) error {
 	path, unlock, err := from.Get(fileID)
 	if err != nil {
 		return err
@@ -21,9 +29,63 @@ func linkFiles(from *filecache.Cache, toDir string, fileID build.ID, filePath st
 	}
 	_ = os.Remove(newFilePath)
 
-	if err := os.Link(path, newFilePath); err != nil {
+	return copyFile(path, newFilePath, 0644)
+}
+
+// copyTree копирует директорию артефакта from в новую директорию to.
+func copyTree(from, to string) error {
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("unsupported file type %s of %s", d.Type(), path)
+		}
+	})
+}
+
+// copyFile создаёт файл to с содержимым from.
+func copyFile(from, to string, perm os.FileMode) error {
+	src, err := os.Open(from)
+	if err != nil {
 		return err
 	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if !cloneFile(dst, src) {
+		if _, err := io.Copy(dst, src); err != nil {
+			_ = dst.Close()
+			return err
+		}
+	}
 
-	return nil
+	return dst.Close()
 }
@@ -31,7 +31,7 @@ func (w *Worker) downloadFiles(ctx context.Context, job *api.JobSpec) error {
 			return fmt.Errorf("unexpected error in `fileCache.Cache.Get`: %w", err)
 		}
 
-		if err := w.fileCacheClient.Download(ctx, w.fileCache, fileID); err != nil {
+		if err := w.fileCacheClient.Download(ctx, w.fileCache, fileID, filePath); err != nil {
 			w.log.Error("couldn't download the files needed for the build from the coordinator",
 				zap.Error(err),
 				zap.String("file_id", fileID.String()),
@@ -67,7 +67,7 @@ func (w *Worker) downloadArtifacts(ctx context.Context, job *api.JobSpec) error
 			return fmt.Errorf("unexpected error in `artifact.Cache.Get`: %w", err)
 		}
 
-		if err := artifact.Download(ctx, workerID.String(), w.artifacts, artifactsID); err != nil {
+		if err := artifact.Download(ctx, workerID.String(), w.artifacts, artifactsID, w.config.downloadOptions()...); err != nil {
 			w.log.Error("couldn't download artifact from worker",
 				zap.Error(err),
 				zap.String("artifact_id", artifactsID.String()))
@@ -79,3 +79,12 @@ func (w *Worker) downloadArtifacts(ctx context.Context, job *api.JobSpec) error
 
 	return nil
 }
+
+// onFileEvicted сообщает координатору, что исходного файла больше нет в кеше воркера.
+func (w *Worker) onFileEvicted(fileID build.ID) {
+	w.state.removeFile(fileID)
+
+	w.log.Info("source file evicted",
+		zap.String("file_id", fileID.String()),
+		zap.Any("cache", w.fileCache.Stats()))
+}
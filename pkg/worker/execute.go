@@ -3,10 +3,10 @@ package worker
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"os"
-	"os/exec"
+	"path/filepath"
 
 	"gitlab.com/justnurik/distbuild/pkg/api"
 	"gitlab.com/justnurik/distbuild/pkg/build"
@@ -15,12 +15,33 @@ import (
 
 func (w *Worker) executeJob(ctx context.Context, job *api.JobSpec) (jobRes api.JobResult, err error) {
 	jobRes.ID = job.ID
-	sourceDir := os.TempDir()
 
 	logger := w.log.With(zap.String("job_id", job.ID.String()))
 
+	jobDir, err := w.createJobDir(job.ID)
+	if err != nil {
+		logger.Error("failed to create a job directory", zap.Error(err))
+		return jobRes, fmt.Errorf("failed to create a job directory: %w", err)
+	}
+	defer func() {
+		if err != nil && w.config.KeepFailedJobDirs {
+			logger.Info("job directory kept for debugging", zap.String("dir", jobDir))
+			return
+		}
+
+		if err := os.RemoveAll(jobDir); err != nil {
+			logger.Warn("failed to remove the job directory", zap.Error(err), zap.String("dir", jobDir))
+		}
+	}()
+
+	sourceDir := filepath.Join(jobDir, "src")
+	if err := os.Mkdir(sourceDir, 0755); err != nil {
+		logger.Error("failed to create a source directory", zap.Error(err))
+		return jobRes, fmt.Errorf("failed to create a source directory: %w", err)
+	}
+
 	for fileID, filePath := range job.SourceFiles {
-		if err := linkFiles(w.fileCache, sourceDir, fileID, filePath); err != nil {
+		if err := copySourceFile(w.fileCache, sourceDir, fileID, filePath); err != nil {
 			logger.Error("couldn't copy all the necessary files to run the command",
 				zap.Error(err),
 				zap.String("file_id", fileID.String()),
@@ -29,6 +50,19 @@ func (w *Worker) executeJob(ctx context.Context, job *api.JobSpec) (jobRes api.J
 		}
 	}
 
+	deps := make(map[build.ID]string, len(job.Deps))
+	for _, depJobID := range job.Deps {
+		depDir := filepath.Join(jobDir, "deps", depJobID.String())
+		if err := w.copyArtifact(depJobID, depDir); err != nil {
+			logger.Error("couldn't copy the dependency artifact",
+				zap.Error(err),
+				zap.String("dep_id", depJobID.String()))
+			return jobRes, fmt.Errorf("couldn't copy the dependency artifact %s: %w", depJobID, err)
+		}
+
+		deps[depJobID] = depDir
+	}
+
 	outputDir, commit, abort, err := w.artifacts.Create(job.ID)
 	if err != nil {
 		logger.Error("failed to create an artifact", zap.Error(err))
@@ -38,19 +72,28 @@ func (w *Worker) executeJob(ctx context.Context, job *api.JobSpec) (jobRes api.J
 	jobContext := build.JobContext{
 		SourceDir: sourceDir,
 		OutputDir: outputDir,
-		Deps:      make(map[build.ID]string),
+		Deps:      deps,
 	}
 
-	unlocks := make([]func(), 0)
-
-	for _, depJobID := range job.Deps {
-		dir, unlock, err := w.artifacts.Get(depJobID)
+	var sb *sandbox
+	if w.config.Sandbox {
+		sb, err = newSandbox(filepath.Join(jobDir, "sandbox"), sourceDir, outputDir, deps, w.config.SandboxReadOnlyPaths)
 		if err != nil {
-			panic("top sort does not worlk")
+			if abortErr := abort(); abortErr != nil {
+				logger.Error("failed abort", zap.Error(abortErr))
+			}
+
+			logger.Error("failed to prepare the sandbox", zap.Error(err))
+			return jobRes, fmt.Errorf("failed to prepare the sandbox: %w", err)
 		}
-		unlocks = append(unlocks, unlock)
+	}
+
+	runner := &commandRunner{sandbox: sb, limits: job.Limits}
 
-		jobContext.Deps[depJobID] = dir
+	if job.Limits.WallTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Limits.WallTime)
+		defer cancel()
 	}
 
 	for i := range job.Cmds {
@@ -59,8 +102,13 @@ func (w *Worker) executeJob(ctx context.Context, job *api.JobSpec) (jobRes api.J
 		stdout := bytes.Buffer{}
 		stderr := bytes.Buffer{}
 
-		jobRes.ExitCode, err = executeCommand(ctx, cmd, &stdout, &stderr)
+		jobRes.ExitCode, err = runner.run(ctx, cmd, &stdout, &stderr)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: wall time limit %s exceeded: %w", errTimedOut, job.Limits.WallTime, err)
+		}
 		if err != nil {
+			jobRes.Resources = &runner.usage
+			jobRes.TimedOut = errors.Is(err, errTimedOut)
 
 			logger.Error("failed job",
 				zap.Error(err), zap.Int("exit_code", jobRes.ExitCode))
@@ -80,8 +128,25 @@ func (w *Worker) executeJob(ctx context.Context, job *api.JobSpec) (jobRes api.J
 		jobRes.Stdout = append(jobRes.Stdout, stdout.Bytes()...)
 	}
 
-	for i := range unlocks {
-		unlocks[i]()
+	usage, err := runner.finish(outputDir)
+	jobRes.Resources = &usage
+	if err != nil {
+		logger.Error("failed job", zap.Error(err), zap.Any("resources", usage))
+
+		errMsg := err.Error()
+		jobRes.Error = &errMsg
+
+		if err := abort(); err != nil {
+			logger.Error("failed abort", zap.Error(err))
+			return jobRes, fmt.Errorf("failed abort: %w", err)
+		}
+
+		return jobRes, fmt.Errorf("failed job: %w", err)
+	}
+
+	if err := w.persistJobResult(&jobRes); err != nil {
+		// Без сохранённого результата джоб, взятый из кеша, не воспроизведёт свой вывод.
+		logger.Warn("couldn't persist the job result", zap.Error(err))
 	}
 
 	if err := commit(); err != nil {
@@ -92,27 +157,25 @@ func (w *Worker) executeJob(ctx context.Context, job *api.JobSpec) (jobRes api.J
 	return jobRes, nil
 }
 
-func executeCommand(ctx context.Context, cmd *build.Cmd, stdout, stderr io.Writer) (int, error) {
-	if len(cmd.Exec) > 0 {
-		execCommand := exec.CommandContext(ctx, cmd.Exec[0], cmd.Exec[1:]...)
-
-		execCommand.Dir = cmd.WorkingDirectory
-		execCommand.Env = cmd.Environ
-
-		execCommand.Stdout = stdout
-		execCommand.Stderr = stderr
-
-		return execCommand.ProcessState.ExitCode(), execCommand.Run()
+// copyArtifact копирует артефакт зависимости в dir, чтобы джоб не мог испортить кеш артефактов.
+func (w *Worker) copyArtifact(jobID build.ID, dir string) error {
+	artifactDir, unlock, err := w.artifacts.Get(jobID)
+	if err != nil {
+		return fmt.Errorf("dependency artifact is missing: %w", err)
 	}
+	defer unlock()
 
-	if len(cmd.CatOutput) > 0 {
-		f, err := os.Create(cmd.CatOutput)
-		if err == nil {
-			_, err = f.WriteString(cmd.CatTemplate)
-		}
+	return copyTree(artifactDir, dir)
+}
 
-		return 0, err
+// createJobDir создаёт пустую директорию, в которой исполняется один запуск джоба.
+//
+// Директории уникальны, поэтому параллельные джобы и повторные запуски одного джоба не видят файлы друг друга.
+func (w *Worker) createJobDir(jobID build.ID) (string, error) {
+	jobsDir := w.config.jobsDir()
+	if err := os.MkdirAll(jobsDir, 0755); err != nil {
+		return "", err
 	}
 
-	return 0, nil
+	return os.MkdirTemp(jobsDir, jobID.String()+"-")
 }
@@ -0,0 +1,171 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"gitlab.com/justnurik/distbuild/pkg/api"
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// Команду с песочницей или ограничениями ресурсов воркер запускает в два шага. Сначала он
+// перезапускает собственный бинарь, передавая описание запуска через переменную окружения
+// helperSpecEnv (для песочницы - в новых user, mount, pid, network, ipc и uts namespace-ах).
+// Перезапущенный процесс в RunHelperIfRequested собирает песочницу, выставляет rlimit-ы и исполняет
+// саму команду.
+//
+// Перезапуск нужен потому, что в Go нельзя выполнить код между fork и exec.
+
+const helperSpecEnv = "DISTBUILD_EXEC_SPEC"
+
+// helperExitCode - код выхода, если не удалось подготовить песочницу или ограничения.
+const helperExitCode = 125
+
+// RunHelperIfRequested исполняет команду джоба, если текущий процесс - перезапуск воркера для
+// песочницы или ограничений ресурсов, и в этом случае не возвращается. Иначе ничего не делает.
+//
+// Бинарь, в котором работает Worker (в том числе тестовый), должен вызвать RunHelperIfRequested
+// первым делом в main или TestMain.
+func RunHelperIfRequested() {
+	spec, ok := os.LookupEnv(helperSpecEnv)
+	if !ok {
+		return
+	}
+
+	err := runHelper(spec)
+	_, _ = fmt.Fprintf(os.Stderr, "distbuild exec helper: %v\n", err)
+	os.Exit(helperExitCode)
+}
+
+type helperSpec struct {
+	Sandbox *sandboxSpec `json:"sandbox,omitempty"`
+	Rlimits []rlimit     `json:"rlimits,omitempty"`
+
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+	Env  []string `json:"env"`
+	Dir  string   `json:"dir"`
+}
+
+type rlimit struct {
+	Resource int    `json:"resource"`
+	Value    uint64 `json:"value"`
+}
+
+// rlimits переводит ограничения джоба в rlimit-ы одной команды.
+//
+// rlimit-ы действуют на каждый процесс по отдельности и обходятся через fork, а RLIMIT_AS ограничивает
+// виртуальную память, которую Go и JVM резервируют с запасом. Поэтому память и процессорное время
+// джоба проверяет limitWatcher по всей группе процессов команды, а rlimit-ом остаётся только размер
+// записываемого файла: он лишь раньше обрывает запись, которую потом всё равно отверг бы Output.
+func rlimits(limits build.Limits) []rlimit {
+	var l []rlimit
+	if limits.Output > 0 {
+		l = append(l, rlimit{Resource: unix.RLIMIT_FSIZE, Value: uint64(limits.Output)})
+	}
+	return l
+}
+
+// newExecCommand возвращает команду, которая исполнит cmd в песочнице sb (если она не nil)
+// с ограничениями limits.
+func newExecCommand(ctx context.Context, cmd *build.Cmd, sb *sandbox, limits build.Limits) (*exec.Cmd, error) {
+	spec := helperSpec{Rlimits: rlimits(limits)}
+
+	if sb == nil && len(spec.Rlimits) == 0 {
+		execCommand := exec.CommandContext(ctx, cmd.Exec[0], cmd.Exec[1:]...)
+		execCommand.Dir = cmd.WorkingDirectory
+		execCommand.Env = cmd.Environ
+		return execCommand, nil
+	}
+
+	path, err := exec.LookPath(cmd.Exec[0])
+	if err != nil {
+		return nil, err
+	}
+
+	spec.Path = path
+	spec.Args = cmd.Exec
+	spec.Env = cmd.Environ
+	spec.Dir = cmd.WorkingDirectory
+
+	attr := &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if sb != nil {
+		spec.Sandbox = &sandboxSpec{Root: sb.root, Mounts: sb.mounts}
+
+		attr.Cloneflags = syscall.CLONE_NEWUSER |
+			syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWNET |
+			syscall.CLONE_NEWIPC |
+			syscall.CLONE_NEWUTS
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+
+	rawSpec, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	execCommand := exec.CommandContext(ctx, "/proc/self/exe")
+	execCommand.Env = []string{helperSpecEnv + "=" + string(rawSpec)}
+	execCommand.SysProcAttr = attr
+	return execCommand, nil
+}
+
+// runHelper исполняется в перезапущенном воркере и при успехе не возвращается.
+func runHelper(rawSpec string) error {
+	var spec helperSpec
+	if err := json.Unmarshal([]byte(rawSpec), &spec); err != nil {
+		return fmt.Errorf("decode spec: %w", err)
+	}
+
+	dir := spec.Dir
+	if spec.Sandbox != nil {
+		if err := enterSandbox(spec.Sandbox); err != nil {
+			return fmt.Errorf("sandbox: %w", err)
+		}
+
+		if dir == "" {
+			dir = "/"
+		}
+	}
+
+	if dir != "" {
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range spec.Rlimits {
+		if err := unix.Setrlimit(l.Resource, &unix.Rlimit{Cur: l.Value, Max: l.Value}); err != nil {
+			return fmt.Errorf("setrlimit %d: %w", l.Resource, err)
+		}
+	}
+
+	return unix.Exec(spec.Path, spec.Args, spec.Env)
+}
+
+// addRusage добавляет к usage ресурсы, потраченные завершившейся командой.
+func addRusage(usage *api.ResourceUsage, state *os.ProcessState) {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return
+	}
+
+	usage.UserTime += time.Duration(rusage.Utime.Nano())
+	usage.SystemTime += time.Duration(rusage.Stime.Nano())
+
+	// На Linux ru_maxrss измеряется в килобайтах.
+	if maxRSS := rusage.Maxrss * 1024; maxRSS > usage.MaxRSS {
+		usage.MaxRSS = maxRSS
+	}
+}
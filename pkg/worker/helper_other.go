@@ -0,0 +1,35 @@
+//go:build !linux
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+
+	"gitlab.com/justnurik/distbuild/pkg/api"
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+var errLimitsUnsupported = errors.New("cpu and memory limits are supported only on linux")
+
+// RunHelperIfRequested нужна только на Linux, где воркер перезапускает себя для песочницы
+// и ограничений ресурсов.
+func RunHelperIfRequested() {}
+
+func newExecCommand(ctx context.Context, cmd *build.Cmd, sb *sandbox, limits build.Limits) (*exec.Cmd, error) {
+	if sb != nil {
+		return nil, errSandboxUnsupported
+	}
+	if limits.CPUTime > 0 || limits.Memory > 0 {
+		return nil, errLimitsUnsupported
+	}
+
+	execCommand := exec.CommandContext(ctx, cmd.Exec[0], cmd.Exec[1:]...)
+	execCommand.Dir = cmd.WorkingDirectory
+	execCommand.Env = cmd.Environ
+	return execCommand, nil
+}
+
+func addRusage(usage *api.ResourceUsage, state *os.ProcessState) {}
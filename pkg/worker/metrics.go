@@ -21,6 +21,8 @@ type workerMetrics struct {
 	activeJob atomic.Int64
 }
 
+// newWorkerMetrics считает джобы воркера и раз в duration рисует в iow число исполняющихся джобов.
+// Если iow == nil, метрики только считаются.
 func newWorkerMetrics(iow io.WriteCloser, duration time.Duration) *workerMetrics {
 	w := &workerMetrics{
 		close:    make(chan struct{}),
@@ -28,8 +30,10 @@ func newWorkerMetrics(iow io.WriteCloser, duration time.Duration) *workerMetrics
 		duration: duration,
 	}
 
-	w.wg.Add(1)
-	go w.show()
+	if iow != nil {
+		w.wg.Add(1)
+		go w.show()
+	}
 
 	return w
 }
@@ -62,5 +66,8 @@ func (w *workerMetrics) doneTask() {
 func (w *workerMetrics) stop() {
 	close(w.close)
 	w.wg.Wait()
-	w.iowc.Close()
+
+	if w.iowc != nil {
+		_ = w.iowc.Close()
+	}
 }
@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// limitsPollInterval - как часто воркер проверяет память и процессорное время команды.
+const limitsPollInterval = 20 * time.Millisecond
+
+// userHZ - единица измерения времени в /proc/<pid>/stat. На Linux она не зависит от ядра.
+const userHZ = 100
+
+// limitWatcher следит за группой процессов одной команды и убивает её, когда джоб превышает
+// ограничения на память или процессорное время.
+//
+// Ограничения считаются по всей группе: память - как сумма RSS живых процессов группы, процессорное
+// время - вместе с временем уже завершившихся команд джоба и дождавшихся потомков. Поэтому fork
+// не позволяет обойти ограничения. Не учитываются только потомки, которые сами вышли из группы
+// процессов (например, через setsid).
+type limitWatcher struct {
+	done    chan struct{}
+	stopped chan struct{}
+
+	peakRSS int64
+	err     error
+}
+
+// watch начинает следить за группой процессов pgid. spent - процессорное время, уже потраченное джобом.
+func (r *commandRunner) watch(pgid int, spent time.Duration) *limitWatcher {
+	w := &limitWatcher{done: make(chan struct{}), stopped: make(chan struct{})}
+	if r.limits.Memory <= 0 && r.limits.CPUTime <= 0 {
+		close(w.stopped)
+		return w
+	}
+
+	go func() {
+		defer close(w.stopped)
+
+		ticker := time.NewTicker(limitsPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+
+			rss, cpu, err := groupUsage(pgid)
+			if err != nil {
+				continue
+			}
+			w.peakRSS = max(w.peakRSS, rss)
+
+			switch {
+			case r.limits.Memory > 0 && rss > r.limits.Memory:
+				w.err = fmt.Errorf("%w: %d bytes in use, limit is %d", errMemoryLimitExceeded, rss, r.limits.Memory)
+			case r.limits.CPUTime > 0 && spent+cpu > r.limits.CPUTime:
+				w.err = fmt.Errorf("%w: %s spent, limit is %s", errCPULimitExceeded, spent+cpu, r.limits.CPUTime)
+			default:
+				continue
+			}
+
+			_ = unix.Kill(-pgid, unix.SIGKILL)
+			return
+		}
+	}()
+
+	return w
+}
+
+// stop останавливает слежку и возвращает наибольший суммарный RSS группы и ошибку, если группа была убита.
+func (w *limitWatcher) stop() (int64, error) {
+	select {
+	case <-w.stopped:
+	default:
+		close(w.done)
+		<-w.stopped
+	}
+	return w.peakRSS, w.err
+}
+
+// groupUsage возвращает суммарный RSS и процессорное время живых процессов группы pgid.
+func groupUsage(pgid int) (rss int64, cpu time.Duration, err error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pageSize := int64(os.Getpagesize())
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+
+		// Процесс мог завершиться, пока мы читали /proc.
+		stat, err := os.ReadFile("/proc/" + e.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+
+		// Имя процесса в скобках может содержать пробелы, поэтому поля считаются от последней скобки.
+		i := strings.LastIndexByte(string(stat), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 22 {
+			continue
+		}
+
+		if group, err := strconv.Atoi(fields[2]); err != nil || group != pgid {
+			continue
+		}
+
+		// utime, stime, cutime и cstime: время процесса и его потомков, которых он дождался.
+		var ticks int64
+		for _, f := range fields[11:15] {
+			n, _ := strconv.ParseInt(f, 10, 64)
+			ticks += n
+		}
+		cpu += time.Duration(ticks) * time.Second / userHZ
+
+		pages, _ := strconv.ParseInt(fields[21], 10, 64)
+		rss += pages * pageSize
+	}
+
+	return rss, cpu, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package worker
+
+import "time"
+
+// limitWatcher нужен только на Linux: на других системах ограничения на память и процессорное время
+// не поддерживаются (см. newExecCommand).
+type limitWatcher struct{}
+
+func (r *commandRunner) watch(pgid int, spent time.Duration) *limitWatcher {
+	return &limitWatcher{}
+}
+
+func (w *limitWatcher) stop() (int64, error) {
+	return 0, nil
+}
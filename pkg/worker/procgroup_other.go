@@ -0,0 +1,9 @@
+//go:build !unix
+
+package worker
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = killWaitDelay
+}
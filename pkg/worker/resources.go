@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gitlab.com/justnurik/distbuild/pkg/api"
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+var (
+	errOutputLimitExceeded = errors.New("output limit exceeded")
+	errMemoryLimitExceeded = errors.New("memory limit exceeded")
+	errCPULimitExceeded    = errors.New("cpu time limit exceeded")
+
+	// errTimedOut оборачивают ошибки джобов, которые не уложились в таймаут.
+	errTimedOut = errors.New("timed out")
+)
+
+// killWaitDelay - сколько ждать закрытия stdout и stderr после того, как команда убита.
+// Без этого потомок, унаследовавший вывод и переживший команду, держал бы джоб вечно.
+const killWaitDelay = time.Second
+
+// commandRunner исполняет команды одного джоба и считает потраченные ими ресурсы.
+type commandRunner struct {
+	sandbox *sandbox
+	limits  build.Limits
+
+	mu      sync.Mutex
+	usage   api.ResourceUsage
+	outputs int64
+
+	outputExceeded bool
+}
+
+// run исполняет команду и возвращает её код выхода.
+func (r *commandRunner) run(ctx context.Context, cmd *build.Cmd, stdout, stderr io.Writer) (int, error) {
+	if len(cmd.Exec) > 0 {
+		cmdCtx := ctx
+		if cmd.Timeout > 0 {
+			var cancel context.CancelFunc
+			cmdCtx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+			defer cancel()
+		}
+
+		execCommand, err := newExecCommand(cmdCtx, cmd, r.sandbox, r.limits)
+		if err != nil {
+			return -1, err
+		}
+		setProcessGroup(execCommand)
+
+		execCommand.Stdout = &countingWriter{r: r, w: stdout}
+		execCommand.Stderr = &countingWriter{r: r, w: stderr}
+
+		if err := execCommand.Start(); err != nil {
+			return -1, err
+		}
+
+		watcher := r.watch(execCommand.Process.Pid, r.usage.UserTime+r.usage.SystemTime)
+		err = execCommand.Wait()
+		peakRSS, limitErr := watcher.stop()
+
+		if execCommand.ProcessState != nil {
+			addRusage(&r.usage, execCommand.ProcessState)
+		}
+		r.usage.MaxRSS = max(r.usage.MaxRSS, peakRSS)
+		if limitErr == nil {
+			// Команда могла превысить ограничение между проверками.
+			limitErr = r.checkLimits()
+		}
+
+		if r.exceeded() {
+			// Команда, скорее всего, упала с SIGPIPE, но настоящая причина - лимит.
+			err = errOutputLimitExceeded
+		} else if limitErr != nil {
+			err = limitErr
+		}
+		if err != nil && ctx.Err() == nil && errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: command %q exceeded timeout %s", errTimedOut, cmd.Exec[0], cmd.Timeout)
+		}
+		return execCommand.ProcessState.ExitCode(), err
+	}
+
+	if len(cmd.CatOutput) > 0 {
+		f, err := os.Create(cmd.CatOutput)
+		if err == nil {
+			_, err = f.WriteString(cmd.CatTemplate)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// checkLimits проверяет ограничения на память и процессорное время по уже потраченным ресурсам.
+func (r *commandRunner) checkLimits() error {
+	if r.limits.Memory > 0 && r.usage.MaxRSS > r.limits.Memory {
+		return fmt.Errorf("%w: peak rss %d bytes, limit is %d", errMemoryLimitExceeded, r.usage.MaxRSS, r.limits.Memory)
+	}
+
+	if spent := r.usage.UserTime + r.usage.SystemTime; r.limits.CPUTime > 0 && spent > r.limits.CPUTime {
+		return fmt.Errorf("%w: %s spent, limit is %s", errCPULimitExceeded, spent, r.limits.CPUTime)
+	}
+	return nil
+}
+
+// finish дописывает к потраченным ресурсам размер выходной директории и проверяет лимит на вывод.
+func (r *commandRunner) finish(outputDir string) (api.ResourceUsage, error) {
+	size, err := dirSize(outputDir)
+	if err != nil {
+		return r.usage, err
+	}
+
+	r.usage.BytesWritten = r.outputs + size
+	if r.limits.Output > 0 && r.usage.BytesWritten > r.limits.Output {
+		return r.usage, errOutputLimitExceeded
+	}
+
+	return r.usage, nil
+}
+
+func (r *commandRunner) add(n int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.outputs += int64(n)
+	if r.limits.Output > 0 && r.outputs > r.limits.Output {
+		r.outputExceeded = true
+	}
+	return !r.outputExceeded
+}
+
+func (r *commandRunner) exceeded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.outputExceeded
+}
+
+// countingWriter считает вывод команды и обрывает его, когда превышен лимит.
+type countingWriter struct {
+	r *commandRunner
+	w io.Writer
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if !w.r.add(len(p)) {
+		return 0, errOutputLimitExceeded
+	}
+	return w.w.Write(p)
+}
+
+// dirSize возвращает суммарный размер обычных файлов в dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"gitlab.com/justnurik/distbuild/pkg/api"
+	"gitlab.com/justnurik/distbuild/pkg/artifact"
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// cachedJobResult возвращает результат джоба, артефакт которого уже лежит в кеше.
+//
+// Результат читается из метаданных артефакта при первом обращении, поэтому переживает перезапуск воркера.
+func (w *Worker) cachedJobResult(jobID build.ID) (*api.JobResult, bool) {
+	// Артефакт мог быть вытеснен, поэтому его наличие проверяется всегда. Заодно Get отмечает его использование.
+	_, unlock, err := w.artifacts.Get(jobID)
+	if err != nil {
+		return nil, false
+	}
+	unlock()
+
+	if jobRes, exist := w.jobResultCache.Load(jobID); exist {
+		return jobRes, true
+	}
+
+	jobRes := &api.JobResult{ID: jobID}
+
+	meta, err := w.artifacts.ReadMeta(jobID)
+	switch {
+	case errors.Is(err, artifact.ErrNotFound):
+		// Например, артефакт скачан с другого воркера как зависимость: вывод джоба неизвестен.
+	case err != nil:
+		w.log.Warn("couldn't read the cached job result", zap.Error(err), zap.String("job_id", jobID.String()))
+	default:
+		if err := json.Unmarshal(meta, jobRes); err != nil {
+			w.log.Warn("couldn't decode the cached job result", zap.Error(err), zap.String("job_id", jobID.String()))
+			jobRes = &api.JobResult{ID: jobID}
+		}
+	}
+
+	w.jobResultCache.Store(jobID, jobRes)
+	return jobRes, true
+}
+
+// persistJobResult сохраняет результат успешного джоба в метаданные его артефакта.
+func (w *Worker) persistJobResult(jobRes *api.JobResult) error {
+	meta, err := json.Marshal(cachedResult(jobRes))
+	if err != nil {
+		return err
+	}
+
+	return w.artifacts.WriteMeta(jobRes.ID, meta)
+}
+
+// cachedResult возвращает ту часть результата, которую воспроизводит кеш. Ресурсы не сохраняются:
+// джоб, взятый из кеша, не исполнялся.
+func cachedResult(jobRes *api.JobResult) *api.JobResult {
+	return &api.JobResult{
+		ID:       jobRes.ID,
+		Stdout:   jobRes.Stdout,
+		Stderr:   jobRes.Stderr,
+		ExitCode: jobRes.ExitCode,
+	}
+}
+
+// onArtifactEvicted забывает результат вытесненного джоба и сообщает координатору, что артефакта больше нет.
+func (w *Worker) onArtifactEvicted(jobID build.ID) {
+	w.jobResultCache.Delete(jobID)
+	w.state.removeArtifact(jobID)
+
+	w.log.Info("artifact evicted",
+		zap.String("job_id", jobID.String()),
+		zap.Any("cache", w.artifacts.Stats()))
+}
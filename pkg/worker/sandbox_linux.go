@@ -0,0 +1,184 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+// sandbox описывает, какие пути видны командам одного джоба.
+type sandbox struct {
+	// root - пустая директория, на которую монтируется корень песочницы.
+	root   string
+	mounts []sandboxMount
+}
+
+// sandboxSpec - описание песочницы, которое передаётся перезапущенному воркеру.
+type sandboxSpec struct {
+	Root   string         `json:"root"`
+	Mounts []sandboxMount `json:"mounts"`
+}
+
+type sandboxMount struct {
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// newSandbox готовит песочницу для джоба, которому доступны sourceDir, outputDir и deps на запись,
+// а readOnlyPaths - только на чтение.
+func newSandbox(root, sourceDir, outputDir string, deps map[build.ID]string, readOnlyPaths []string) (*sandbox, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &sandbox{root: root}
+	for _, path := range readOnlyPaths {
+		s.mounts = append(s.mounts, sandboxMount{Path: path, ReadOnly: true})
+	}
+	for _, dir := range deps {
+		s.mounts = append(s.mounts, sandboxMount{Path: dir, ReadOnly: true})
+	}
+	s.mounts = append(s.mounts,
+		sandboxMount{Path: sourceDir},
+		sandboxMount{Path: outputDir},
+	)
+
+	return s, nil
+}
+
+// enterSandbox исполняется внутри новых namespace-ов и делает корнем файловой системы
+// процесса корень песочницы.
+func enterSandbox(spec *sandboxSpec) error {
+	// Монтирования не должны просачиваться обратно в namespace воркера.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+
+	if err := unix.Mount("tmpfs", spec.Root, "tmpfs", 0, "mode=0755"); err != nil {
+		return fmt.Errorf("mount root: %w", err)
+	}
+
+	for _, m := range spec.Mounts {
+		if err := bindMount(spec.Root, m); err != nil {
+			return fmt.Errorf("mount %s: %w", m.Path, err)
+		}
+	}
+
+	for _, dev := range []string{"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom"} {
+		if err := bindMount(spec.Root, sandboxMount{Path: dev}); err != nil {
+			return fmt.Errorf("mount %s: %w", dev, err)
+		}
+	}
+
+	tmp := filepath.Join(spec.Root, "tmp")
+	if err := os.MkdirAll(tmp, 0777); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", tmp, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+
+	proc := filepath.Join(spec.Root, "proc")
+	if err := os.MkdirAll(proc, 0555); err != nil {
+		return err
+	}
+	if err := unix.Mount("proc", proc, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mount /proc: %w", err)
+	}
+
+	return pivotRoot(spec.Root)
+}
+
+// bindMount делает путь m.Path хоста видимым по тому же пути внутри root.
+func bindMount(root string, m sandboxMount) error {
+	info, err := os.Lstat(m.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	target := filepath.Join(root, m.Path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		// Например, /lib -> usr/lib: достаточно повторить ссылку, цель монтируется отдельно.
+		link, err := os.Readlink(m.Path)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	case info.IsDir():
+		err = os.MkdirAll(target, 0755)
+	default:
+		var f *os.File
+		f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := unix.Mount(m.Path, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+
+	if !m.ReadOnly {
+		return nil
+	}
+
+	// В user namespace нельзя снять флаги, которыми защищено исходное монтирование,
+	// поэтому при перемонтировании их нужно повторить.
+	var st unix.Statfs_t
+	if err := unix.Statfs(m.Path, &st); err != nil {
+		return err
+	}
+
+	flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY)
+	for stFlag, msFlag := range map[int64]uintptr{
+		unix.ST_NOSUID:     unix.MS_NOSUID,
+		unix.ST_NODEV:      unix.MS_NODEV,
+		unix.ST_NOEXEC:     unix.MS_NOEXEC,
+		unix.ST_NOATIME:    unix.MS_NOATIME,
+		unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+		unix.ST_RELATIME:   unix.MS_RELATIME,
+	} {
+		if int64(st.Flags)&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+
+	return unix.Mount("", target, "", flags, "")
+}
+
+// pivotRoot делает root корнем файловой системы и отмонтирует старый корень.
+func pivotRoot(root string) error {
+	oldRoot := filepath.Join(root, ".old_root")
+	if err := os.Mkdir(oldRoot, 0700); err != nil {
+		return err
+	}
+
+	if err := unix.PivotRoot(root, oldRoot); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+
+	if err := unix.Unmount("/.old_root", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root: %w", err)
+	}
+
+	return os.Remove("/.old_root")
+}
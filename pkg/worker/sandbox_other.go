@@ -0,0 +1,17 @@
+//go:build !linux
+
+package worker
+
+import (
+	"errors"
+
+	"gitlab.com/justnurik/distbuild/pkg/build"
+)
+
+var errSandboxUnsupported = errors.New("sandbox is supported only on linux")
+
+type sandbox struct{}
+
+func newSandbox(root, sourceDir, outputDir string, deps map[build.ID]string, readOnlyPaths []string) (*sandbox, error) {
+	return nil, errSandboxUnsupported
+}
@@ -2,63 +2,228 @@ package worker
 
 import (
 	"context"
-	"runtime"
+	"slices"
 
 	"gitlab.com/justnurik/distbuild/pkg/api"
 	"gitlab.com/justnurik/distbuild/pkg/build"
 )
 
+// inventoryKind описывает опись кеша артефактов, которую нужно отправить координатору.
+type inventoryKind int
+
+const (
+	inventoryNone inventoryKind = iota
+	inventoryDigest
+	inventoryFull
+)
+
 type workerState struct {
 	mu chan struct{}
 
-	AddedArtifacts []build.ID
-	FinishedJob    []api.JobResult
-	FreeSlots      int
+	AddedArtifacts   []build.ID
+	RemovedArtifacts []build.ID
+	RemovedFiles     []build.ID
+	FinishedJob      []api.JobResult
+	FreeSlots        int
+
+	// inventory задаёт, какую опись кеша артефактов приложить к следующему heartbeat-у.
+	inventory inventoryKind
+
+	// slotFreed получает сигнал, когда освобождается слот.
+	slotFreed chan struct{}
+
+	// reported получает сигнал, когда появляется результат, о котором нужно сообщить координатору.
+	reported chan struct{}
 }
 
-func newWorkerState() *workerState {
+func newWorkerState(slots int) *workerState {
 	w := &workerState{
 		mu: make(chan struct{}, 1),
 
-		AddedArtifacts: make([]build.ID, 0),
-		FinishedJob:    make([]api.JobResult, 0),
+		AddedArtifacts:   make([]build.ID, 0),
+		RemovedArtifacts: make([]build.ID, 0),
+		RemovedFiles:     make([]build.ID, 0),
+		FinishedJob:      make([]api.JobResult, 0),
+
+		FreeSlots: slots,
 
-		FreeSlots: runtime.NumCPU(),
+		// Первый heartbeat сообщает координатору дайджест кеша артефактов.
+		inventory: inventoryDigest,
+
+		slotFreed: make(chan struct{}, 1),
+		reported:  make(chan struct{}, 1),
 	}
 	return w
 }
 
-func (w *workerState) pull(workerID api.WorkerID) *api.HeartbeatRequest {
+func (w *workerState) lock() func() {
+	w.mu <- struct{}{}
+	return func() { <-w.mu }
+}
+
+// pull забирает накопленные результаты. Если withSlots, в запрос попадают свободные слоты,
+// иначе запрос только сообщает результаты и что воркер жив.
+//
+// pull также возвращает, какую опись кеша артефактов нужно приложить к запросу.
+func (w *workerState) pull(workerID api.WorkerID, withSlots bool) (*api.HeartbeatRequest, inventoryKind) {
+	defer w.lock()()
+
 	request := &api.HeartbeatRequest{
-		WorkerID:       workerID,
-		FreeSlots:      w.FreeSlots,
-		FinishedJob:    w.FinishedJob,
-		AddedArtifacts: w.AddedArtifacts,
+		WorkerID:         workerID,
+		FinishedJob:      w.FinishedJob,
+		AddedArtifacts:   w.AddedArtifacts,
+		RemovedArtifacts: w.RemovedArtifacts,
+		RemovedFiles:     w.RemovedFiles,
 	}
+	if withSlots {
+		request.FreeSlots = w.FreeSlots
+	}
+
 	w.AddedArtifacts = make([]build.ID, 0)
+	w.RemovedArtifacts = make([]build.ID, 0)
+	w.RemovedFiles = make([]build.ID, 0)
 	w.FinishedJob = make([]api.JobResult, 0)
 
-	return request
+	inventory := w.inventory
+	w.inventory = inventoryNone
+
+	return request, inventory
+}
+
+// restore возвращает в очередь результаты и опись запроса, который не дошёл до координатора.
+func (w *workerState) restore(request *api.HeartbeatRequest) {
+	if request.Inventory != nil {
+		inventory := inventoryDigest
+		if request.Inventory.Full {
+			inventory = inventoryFull
+		}
+		w.requestInventory(inventory)
+	}
+
+	if !hasResults(request) {
+		return
+	}
+
+	unlock := w.lock()
+	// Артефакт, который появился снова после неотправленного удаления, удалённым не считается.
+	removed := slices.DeleteFunc(request.RemovedArtifacts, w.readded)
+	w.RemovedArtifacts = append(removed, w.RemovedArtifacts...)
+	w.RemovedFiles = append(request.RemovedFiles, w.RemovedFiles...)
+
+	w.FinishedJob = append(request.FinishedJob, w.FinishedJob...)
+	w.AddedArtifacts = append(request.AddedArtifacts, w.AddedArtifacts...)
+	unlock()
+
+	notify(w.reported)
+}
+
+// requestInventory просит приложить опись кеша артефактов к ближайшему heartbeat-у.
+func (w *workerState) requestInventory(inventory inventoryKind) {
+	unlock := w.lock()
+	w.inventory = max(w.inventory, inventory)
+	unlock()
+
+	notify(w.reported)
+}
+
+func (w *workerState) freeSlots() int {
+	defer w.lock()()
+
+	return w.FreeSlots
+}
+
+// takeSlot занимает слот под джоб, полученный от координатора.
+func (w *workerState) takeSlot() {
+	defer w.lock()()
+
+	w.FreeSlots--
+}
+
+// releaseSlot освобождает слот завершившегося джоба.
+func (w *workerState) releaseSlot() {
+	unlock := w.lock()
+	w.FreeSlots++
+	unlock()
+
+	notify(w.slotFreed)
 }
 
 func (w *workerState) addArtifacts(ctx context.Context, artifacts []build.ID) {
 	select {
 	case w.mu <- struct{}{}:
-		defer func() { <-w.mu }()
 	case <-ctx.Done():
 		return
 	}
 
 	w.AddedArtifacts = append(w.AddedArtifacts, artifacts...)
+	w.RemovedArtifacts = slices.DeleteFunc(w.RemovedArtifacts, func(id build.ID) bool {
+		return slices.Contains(artifacts, id)
+	})
+	<-w.mu
+
+	notify(w.reported)
 }
 
 func (w *workerState) addJobResult(ctx context.Context, jobRes *api.JobResult) {
 	select {
 	case w.mu <- struct{}{}:
-		defer func() { <-w.mu }()
 	case <-ctx.Done():
 		return
 	}
 
 	w.FinishedJob = append(w.FinishedJob, *jobRes)
+	if jobRes.Error == nil {
+		w.RemovedArtifacts = slices.DeleteFunc(w.RemovedArtifacts, func(id build.ID) bool {
+			return id == jobRes.ID
+		})
+	}
+	<-w.mu
+
+	notify(w.reported)
+}
+
+// removeArtifact запоминает артефакт, вытесненный из кеша, чтобы сообщить о нём координатору.
+func (w *workerState) removeArtifact(artifact build.ID) {
+	unlock := w.lock()
+	w.AddedArtifacts = slices.DeleteFunc(w.AddedArtifacts, func(id build.ID) bool {
+		return id == artifact
+	})
+	w.RemovedArtifacts = append(w.RemovedArtifacts, artifact)
+	unlock()
+
+	notify(w.reported)
+}
+
+// removeFile запоминает исходный файл, вытесненный из кеша файлов, чтобы сообщить о нём координатору.
+func (w *workerState) removeFile(file build.ID) {
+	unlock := w.lock()
+	w.RemovedFiles = append(w.RemovedFiles, file)
+	unlock()
+
+	notify(w.reported)
+}
+
+// readded сообщает, что артефакт снова появился в кеше после удаления. Вызывается под локом.
+func (w *workerState) readded(artifact build.ID) bool {
+	if slices.Contains(w.AddedArtifacts, artifact) {
+		return true
+	}
+
+	return slices.ContainsFunc(w.FinishedJob, func(jobRes api.JobResult) bool {
+		return jobRes.ID == artifact && jobRes.Error == nil
+	})
+}
+
+// hasResults сообщает, что запрос несёт результаты джобов или изменения кешей.
+func hasResults(request *api.HeartbeatRequest) bool {
+	return len(request.FinishedJob) != 0 || len(request.AddedArtifacts) != 0 ||
+		len(request.RemovedArtifacts) != 0 || len(request.RemovedFiles) != 0
+}
+
+// notify будит того, кто ждёт на ch, не блокируясь.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
 }
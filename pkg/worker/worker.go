@@ -5,8 +5,11 @@ package worker
 import (
 	"context"
 	"fmt"
+	"io"
+	"maps"
 	"net/http"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -37,6 +40,9 @@ type metaData struct {
 	// state
 	state   *workerState
 	metrics *workerMetrics
+
+	// runningJobs хранит функции отмены исполняющихся джобов.
+	runningJobs *concurrency.SyncMap[build.ID, context.CancelFunc]
 }
 
 type cache struct {
@@ -44,12 +50,13 @@ type cache struct {
 	fileCache *filecache.Cache
 	artifacts *artifact.Cache
 
-	// job result cache
+	// jobResultCache хранит в памяти результаты джобов, уже прочитанные из метаданных артефактов.
 	jobResultCache *concurrency.SyncMap[build.ID, *api.JobResult]
 }
 
 type Worker struct {
-	log *zap.Logger
+	log    *zap.Logger
+	config Config
 
 	httpAPI
 	metaData
@@ -62,19 +69,38 @@ func New(
 	log *zap.Logger,
 	fileCache *filecache.Cache,
 	artifacts *artifact.Cache,
+) *Worker {
+	return NewWithConfig(workerID, coordinatorEndpoint, log, fileCache, artifacts, DefaultConfig())
+}
+
+func NewWithConfig(
+	workerID api.WorkerID,
+	coordinatorEndpoint string,
+	log *zap.Logger,
+	fileCache *filecache.Cache,
+	artifacts *artifact.Cache,
+	config Config,
 ) *Worker {
 	mux := http.NewServeMux()
 
 	artifactHandler := artifact.NewHandler(log, artifacts)
 	artifactHandler.Register(mux)
 
-	// todo: normal
-	nums := workerID.String()
-	num := nums[len(nums)-2:]
-	statFile, _ := os.Create("/home/nurik/work/Nurik/distbuild/stat/" + num)
+	var metricsFile io.WriteCloser
+	if config.MetricsFile != "" {
+		f, err := os.Create(config.MetricsFile)
+		if err != nil {
+			log.Warn("failed to create metrics file, metrics are disabled",
+				zap.String("path", config.MetricsFile),
+				zap.Error(err))
+		} else {
+			metricsFile = f
+		}
+	}
 
-	return &Worker{
-		log: log,
+	w := &Worker{
+		log:    log,
+		config: config,
 
 		httpAPI: httpAPI{
 			coordinatorEndpoint: coordinatorEndpoint,
@@ -84,8 +110,10 @@ func New(
 		},
 		metaData: metaData{
 			workerID: workerID,
-			state:    newWorkerState(),
-			metrics:  newWorkerMetrics(statFile, time.Second/10),
+			state:    newWorkerState(config.slots()),
+			metrics:  newWorkerMetrics(metricsFile, time.Second/10),
+
+			runningJobs: concurrency.NewSyncMap[build.ID, context.CancelFunc](0),
 		},
 		cache: cache{
 			fileCache: fileCache,
@@ -94,51 +122,246 @@ func New(
 			jobResultCache: concurrency.NewSyncMap[build.ID, *api.JobResult](0),
 		},
 	}
+
+	artifacts.OnEvict(w.onArtifactEvicted)
+	fileCache.OnEvict(w.onFileEvicted)
+
+	return w
 }
 
 func (w *Worker) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	w.mux.ServeHTTP(rw, r)
 }
 
+// Run исполняет джобы, пока не отменён ctx.
+//
+// Воркер держит занятыми все свои слоты: пока есть свободный слот, он ждёт джобы от координатора
+// heartbeat-ом со свободными слотами, и каждый полученный джоб сразу запускается. О каждом завершённом
+// джобе воркер сообщает отдельным heartbeat-ом без свободных слотов, не дожидаясь остальных джобов.
+//
+// Если координатор недоступен, воркер повторяет heartbeat-ы с экспоненциальной паузой, а
+// неотправленные результаты копит до тех пор, пока координатор не ответит.
 func (w *Worker) Run(ctx context.Context) error {
 	defer w.metrics.stop()
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.reportResults(ctx)
+	}()
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	retry := newBackoff(w.config.ReconnectMinDelay, w.config.ReconnectMaxDelay)
+
 	for cycleNum := 0; ; cycleNum++ {
+		// Когда все слоты заняты, heartbeat нужен только чтобы сообщить, что воркер жив.
+		if w.state.freeSlots() == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-w.state.slotFreed:
+				continue
+			case <-ticker.C:
+			}
+		}
+
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			w.log.Info(fmt.Sprintf("start work cycle: %d", cycleNum))
+			w.log.Debug(fmt.Sprintf("start work cycle: %d", cycleNum))
 		}
 
-		request := w.state.pull(w.workerID)
+		request := w.pull(true)
 
 		response, err := w.heartbeatClient.Heartbeat(ctx, request)
 		if err != nil {
-			w.log.Error("couldn't send a `Heartbeat` request to the coordinator",
+			w.state.restore(request)
+
+			delay := retry.next()
+			w.log.Warn("couldn't send a `Heartbeat` request to the coordinator, retrying",
 				zap.Error(err),
-				zap.Any("request", request))
-			return fmt.Errorf("couldn't send a `Heartbeat` request to the coordinator: %w", err)
+				zap.Duration("delay", delay))
+
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
+			continue
 		}
+		retry.reset()
 
 		w.log.Info(fmt.Sprintf("schedule: %d", len(response.JobsToRun)))
 
-		if len(response.JobsToRun) == 0 {
+		w.handleResponse(response)
+
+		for jobID, job := range response.JobsToRun {
+			w.startJob(ctx, &wg, jobID, job)
+		}
+	}
+}
+
+// startJob занимает слот и исполняет джоб в отдельной горутине.
+func (w *Worker) startJob(ctx context.Context, wg *sync.WaitGroup, jobID build.ID, job api.JobSpec) {
+	w.metrics.scheduleTask()
+	w.state.takeSlot()
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	w.runningJobs.Store(jobID, cancel)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			w.runningJobs.Delete(jobID)
+			cancel()
+			w.state.releaseSlot()
+		}()
+
+		w.runJob(jobCtx, jobID, &job)
+	}()
+}
+
+// keepAliveInterval задаёт, как часто воркер сообщает координатору, что он жив, и забирает отменённые джобы,
+// пока исполняет джобы.
+const keepAliveInterval = 200 * time.Millisecond
+
+// reportResults сообщает координатору о завершённых джобах, как только они появляются.
+// Раз в InventoryInterval он также сверяет с координатором содержимое кеша артефактов.
+//
+// Пока воркер исполняет джобы и при этом ждёт новые, heartbeat основного цикла висит в long-poll,
+// и координатору негде вернуть отменённые джобы. Поэтому в это время reportResults раз в keepAliveInterval
+// шлёт heartbeat без свободных слотов, даже если сообщать нечего.
+func (w *Worker) reportResults(ctx context.Context) {
+	retry := newBackoff(w.config.ReconnectMinDelay, w.config.ReconnectMaxDelay)
+
+	inventoryTicker := time.NewTicker(w.config.inventoryInterval())
+	defer inventoryTicker.Stop()
+
+	keepAliveTicker := time.NewTicker(keepAliveInterval)
+	defer keepAliveTicker.Stop()
+
+	for {
+		keepAlive := false
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.state.reported:
+		case <-inventoryTicker.C:
+			w.state.requestInventory(inventoryDigest)
+		case <-keepAliveTicker.C:
+			// Когда свободных слотов нет, heartbeat-ы без слотов шлёт основной цикл.
+			free := w.state.freeSlots()
+			keepAlive = free > 0 && free < w.config.slots()
+		}
+
+		request := w.pull(false)
+		if !hasResults(request) && request.Inventory == nil && !keepAlive {
 			continue
 		}
 
-		var wg sync.WaitGroup
-		wg.Add(len(response.JobsToRun))
+		response, err := w.heartbeatClient.Heartbeat(ctx, request)
+		if err != nil {
+			// restore снова разбудит этот цикл, поэтому после паузы будет новая попытка.
+			w.state.restore(request)
 
-		for jobID, job := range response.JobsToRun {
-			w.metrics.scheduleTask()
-			go func() {
-				defer wg.Done()
-				w.runJob(ctx, jobID, &job)
-			}()
+			delay := retry.next()
+			w.log.Warn("couldn't report results to the coordinator, retrying",
+				zap.Error(err),
+				zap.Duration("delay", delay))
+
+			if sleep(ctx, delay) != nil {
+				return
+			}
+			continue
 		}
+		retry.reset()
+
+		w.handleResponse(response)
+	}
+}
+
+// pull собирает следующий heartbeat и, если нужно, прикладывает к нему опись кеша артефактов.
+func (w *Worker) pull(withSlots bool) *api.HeartbeatRequest {
+	request, inventory := w.state.pull(w.workerID, withSlots)
+
+	// Вытесненный файл мог быть скачан заново для нового джоба. Тогда координатор узнает о нём
+	// из результата этого джоба, а устаревшее удаление стёрло бы это знание.
+	request.RemovedFiles = slices.DeleteFunc(request.RemovedFiles, w.fileCache.Has)
+
+	if inventory == inventoryNone {
+		return request
+	}
+
+	var err error
+	request.Inventory, err = w.inventory(inventory == inventoryFull)
+	if err != nil {
+		// Опись будет отправлена при следующей периодической сверке.
+		w.log.Error("couldn't list the artifact cache", zap.Error(err))
+	}
 
-		wg.Wait()
+	return request
+}
+
+// handleResponse выполняет просьбы координатора из ответа на heartbeat.
+func (w *Worker) handleResponse(response *api.HeartbeatResponse) {
+	w.cancelJobs(response.CancelJobs)
+
+	if response.ResyncArtifacts {
+		w.log.Info("the coordinator requested the artifact inventory")
+		w.state.requestInventory(inventoryFull)
+	}
+}
+
+// inventory описывает содержимое кеша артефактов. Если full, опись содержит полный список артефактов.
+func (w *Worker) inventory(full bool) (*api.ArtifactInventory, error) {
+	var artifacts []build.ID
+	err := w.artifacts.Range(func(artifact build.ID) error {
+		artifacts = append(artifacts, artifact)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := &api.ArtifactInventory{
+		Digest: api.InventoryDigest(artifacts),
+		Full:   full,
+	}
+	if full {
+		inventory.Artifacts = artifacts
+	}
+	return inventory, nil
+}
+
+// sleep ждёт d или отмены ctx.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// cancelJobs останавливает джобы, которые отменил координатор.
+func (w *Worker) cancelJobs(jobs []build.ID) {
+	for _, jobID := range jobs {
+		if cancel, exist := w.runningJobs.Load(jobID); exist {
+			w.log.Info("cancel job", zap.String("job_id", jobID.String()))
+			cancel()
+		}
 	}
 }
 
@@ -154,10 +377,15 @@ func (w *Worker) runJob(ctx context.Context, jobID build.ID, job *api.JobSpec) {
 	}
 	defer func() {
 		w.metrics.doneTask()
+
+		if ctx.Err() != nil {
+			w.log.Info("job cancelled", zap.String("job_id", jobID.String()))
+			return
+		}
 		w.state.addJobResult(ctx, jobRes)
 	}()
 
-	if jobResOther, exist := w.jobResultCache.Load(jobID); exist {
+	if jobResOther, exist := w.cachedJobResult(jobID); exist {
 		w.log.Debug("cache hit", zap.String("job_id", job.ID.String()))
 		jobRes = jobResOther
 		return
@@ -165,6 +393,10 @@ func (w *Worker) runJob(ctx context.Context, jobID build.ID, job *api.JobSpec) {
 
 	w.log.Debug("cache miss", zap.String("job_id", job.ID.String()))
 
+	// Исходные файлы не должны вытесняться между скачиванием и копированием в директорию джоба.
+	release := w.fileCache.Pin(slices.Collect(maps.Keys(job.SourceFiles)))
+	defer release()
+
 	if err := w.downloadArtifacts(ctx, job); err != nil {
 		err := err.Error()
 		jobRes.Error = &err
@@ -189,5 +421,5 @@ func (w *Worker) runJob(ctx context.Context, jobID build.ID, job *api.JobSpec) {
 		return
 	}
 
-	w.jobResultCache.Store(jobID, jobRes)
+	w.jobResultCache.Store(jobID, cachedResult(jobRes))
 }